@@ -0,0 +1,501 @@
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/debugger"
+	"github.com/looplanguage/lpvm/vm"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Loop programs only have a single thread of execution
+const threadId = 1
+
+// Every frame has three scopes, their variables reference encodes the frame and the kind of scope.
+const (
+	scopeLocals = iota
+	scopeFree
+	scopeGlobals
+	scopeCount
+)
+
+// Loader reads the bytecode of the program given in a launch request.
+type Loader func(program string) (*compiler.Bytecode, error)
+
+type server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	load Loader
+
+	mu     sync.Mutex
+	seq    int
+	paused bool
+
+	debugger    *debugger.Debugger
+	stopOnEntry bool
+	// lines holds every source line an instruction starts on, it is nil without a source map
+	lines map[debugger.LineBreakpoint]bool
+}
+
+// Serve speaks the Debug Adapter Protocol on in and out until the client disconnects.
+func Serve(in io.Reader, out io.Writer, load Loader) error {
+	s := &server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		load: load,
+	}
+
+	for {
+		req, err := readRequest(s.in)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !s.handle(req) {
+			return nil
+		}
+	}
+}
+
+// handle answers a single request, it returns false once the session is over.
+func (s *server) handle(req *request) bool {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{"supportsConfigurationDoneRequest": true}, nil)
+		s.send("initialized", nil)
+		return true
+	case "launch":
+		err = s.launch(req.Arguments)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []breakpoint{}}
+	case "configurationDone":
+		err = s.requireLaunched()
+		if err == nil {
+			s.respond(req, nil, nil)
+			s.resume(func() debugger.Event {
+				return s.debugger.Start(context.Background(), s.stopOnEntry)
+			})
+			return true
+		}
+	case "threads":
+		body = map[string]interface{}{"threads": []thread{{Id: threadId, Name: "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes(req.Arguments)
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "continue", "next", "stepIn", "stepOut":
+		err = s.requirePaused()
+		if err == nil {
+			s.respond(req, map[string]bool{"allThreadsContinued": true}, nil)
+			s.step(req.Command)
+			return true
+		}
+	case "pause":
+		err = s.requireLaunched()
+		if err == nil {
+			s.debugger.Pause()
+		}
+	case "disconnect", "terminate":
+		s.stop()
+		s.respond(req, nil, nil)
+		return req.Command != "disconnect"
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+
+	s.respond(req, body, err)
+	return true
+}
+
+func (s *server) launch(raw json.RawMessage) error {
+	var args launchArguments
+
+	err := json.Unmarshal(raw, &args)
+	if err != nil {
+		return err
+	}
+
+	bytecode, err := s.load(args.Program)
+	if err != nil {
+		return err
+	}
+
+	err = vm.Verify(bytecode)
+	if err != nil {
+		return err
+	}
+
+	machine := vm.Create(bytecode)
+
+	sourceMap, err := loadSourceMap(args)
+	if err != nil {
+		return err
+	}
+
+	machine.SetSourceMap(sourceMap)
+
+	s.debugger = debugger.New(machine)
+	s.stopOnEntry = args.StopOnEntry
+	s.lines = sourceLines(sourceMap)
+
+	return nil
+}
+
+// loadSourceMap reads the source map of the launched program. Relative file names are resolved against the
+// directory of the program, so they match the absolute paths editors use for breakpoints.
+func loadSourceMap(args launchArguments) (*vm.SourceMap, error) {
+	path := args.SourceMap
+
+	if path == "" {
+		path = args.Program + ".map"
+
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sourceMap, err := vm.LoadSourceMap(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(args.Program))
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := func(entries []vm.LineEntry) {
+		for i := range entries {
+			if !filepath.IsAbs(entries[i].File) {
+				entries[i].File = filepath.Join(dir, entries[i].File)
+			}
+		}
+	}
+
+	resolve(sourceMap.Main)
+	for _, entries := range sourceMap.Functions {
+		resolve(entries)
+	}
+
+	return sourceMap, nil
+}
+
+func (s *server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	err := s.requireLaunched()
+	if err != nil {
+		return nil, err
+	}
+
+	var args setBreakpointsArguments
+
+	err = json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Clean(args.Source.Path)
+	s.debugger.ClearLineBreakpoints(file)
+
+	// A breakpoint on a line without instructions would never be hit, so it is reported as unverified instead
+	breakpoints := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		lineBreakpoint := debugger.LineBreakpoint{File: file, Line: b.Line}
+
+		switch {
+		case s.lines == nil:
+			breakpoints = append(breakpoints, breakpoint{Line: b.Line, Message: "no source map was loaded for the program"})
+		case !s.lines[lineBreakpoint]:
+			breakpoints = append(breakpoints, breakpoint{Line: b.Line, Message: fmt.Sprintf("no instructions on line %d", b.Line)})
+		default:
+			s.debugger.SetLineBreakpoint(lineBreakpoint)
+			breakpoints = append(breakpoints, breakpoint{Verified: true, Line: b.Line})
+		}
+	}
+
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// sourceLines collects the lines of the source map, the debugger pauses on them when an instruction starts there.
+func sourceLines(sourceMap *vm.SourceMap) map[debugger.LineBreakpoint]bool {
+	if sourceMap == nil {
+		return nil
+	}
+
+	lines := map[debugger.LineBreakpoint]bool{}
+
+	add := func(entries []vm.LineEntry) {
+		for _, entry := range entries {
+			lines[debugger.LineBreakpoint{File: entry.File, Line: entry.Line}] = true
+		}
+	}
+
+	add(sourceMap.Main)
+	for _, entries := range sourceMap.Functions {
+		add(entries)
+	}
+
+	return lines
+}
+
+func (s *server) stackTrace() (interface{}, error) {
+	err := s.requirePaused()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []stackFrame{}
+
+	for i, frame := range s.debugger.VM().Backtrace() {
+		name := "main"
+		if frame.Function != nil {
+			name = fmt.Sprintf("function %d", frame.FunctionId)
+		}
+
+		f := stackFrame{Id: i, Name: fmt.Sprintf("%s (ip=%d)", name, frame.Ip)}
+
+		if frame.Position != nil {
+			f.Source = &source{Name: filepath.Base(frame.Position.File), Path: frame.Position.File}
+			f.Line = frame.Position.Line
+			f.Column = frame.Position.Column
+		}
+
+		frames = append(frames, f)
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *server) scopes(raw json.RawMessage) (interface{}, error) {
+	err := s.requirePaused()
+	if err != nil {
+		return nil, err
+	}
+
+	var args scopesArguments
+
+	err = json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := func(kind int) int {
+		return args.FrameId*scopeCount + kind + 1
+	}
+
+	scopes := []scope{
+		{Name: "Locals", VariablesReference: reference(scopeLocals)},
+		{Name: "Free variables", VariablesReference: reference(scopeFree)},
+		{Name: "Globals", VariablesReference: reference(scopeGlobals), Expensive: true},
+	}
+
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *server) variables(raw json.RawMessage) (interface{}, error) {
+	err := s.requirePaused()
+	if err != nil {
+		return nil, err
+	}
+
+	var args variablesArguments
+
+	err = json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := (args.VariablesReference - 1) / scopeCount
+	machine := s.debugger.VM()
+	variables := []variable{}
+
+	switch (args.VariablesReference - 1) % scopeCount {
+	case scopeLocals:
+		for i, value := range machine.Locals(frame) {
+			variables = append(variables, newVariable(fmt.Sprintf("local %d", i), value))
+		}
+	case scopeFree:
+		for i, value := range machine.Free(frame) {
+			variables = append(variables, newVariable(fmt.Sprintf("free %d", i), value))
+		}
+	case scopeGlobals:
+		variables = append(variables, storeVariables("global", machine.Globals())...)
+		variables = append(variables, storeVariables("var", machine.Variables())...)
+	}
+
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func newVariable(name string, value object.Object) variable {
+	if value == nil {
+		return variable{Name: name, Value: "<unset>"}
+	}
+
+	return variable{Name: name, Value: value.Inspect(), Type: value.Type()}
+}
+
+func storeVariables(name string, store map[int]object.Object) []variable {
+	indices := make([]int, 0, len(store))
+	for index := range store {
+		indices = append(indices, index)
+	}
+
+	sort.Ints(indices)
+
+	variables := make([]variable, 0, len(indices))
+	for _, index := range indices {
+		variables = append(variables, newVariable(fmt.Sprintf("%s %d", name, index), store[index]))
+	}
+
+	return variables
+}
+
+func (s *server) step(command string) {
+	switch command {
+	case "continue":
+		s.resume(s.debugger.Continue)
+	case "next":
+		s.resume(s.debugger.StepOver)
+	case "stepIn":
+		s.resume(s.debugger.StepInto)
+	case "stepOut":
+		s.resume(s.debugger.StepOut)
+	}
+}
+
+// resume runs the program on another goroutine so requests like pause are still handled, the outcome is reported
+// with a stopped or terminated event.
+func (s *server) resume(run func() debugger.Event) {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+
+	go func() {
+		e := run()
+
+		if !e.Done {
+			s.mu.Lock()
+			s.paused = true
+			s.mu.Unlock()
+
+			s.send("stopped", map[string]interface{}{
+				"reason":            string(e.Reason),
+				"threadId":          threadId,
+				"allThreadsStopped": true,
+			})
+			return
+		}
+
+		s.finish(e)
+	}()
+}
+
+func (s *server) finish(e debugger.Event) {
+	exitCode := 0
+
+	if e.Err != nil && !errors.Is(e.Err, debugger.ErrAborted) {
+		exitCode = 1
+
+		message := e.Err.Error()
+
+		var runtimeErr *vm.RuntimeError
+		if errors.As(e.Err, &runtimeErr) {
+			message = runtimeErr.StackTrace()
+		}
+
+		s.send("output", map[string]string{"category": "stderr", "output": message})
+	} else if result := s.debugger.VM().LastPoppedStackElem(); e.Err == nil && result != nil {
+		s.send("output", map[string]string{"category": "stdout", "output": result.Inspect() + "\n"})
+	}
+
+	s.send("exited", map[string]int{"exitCode": exitCode})
+	s.send("terminated", nil)
+}
+
+func (s *server) stop() {
+	if s.debugger == nil {
+		return
+	}
+
+	s.mu.Lock()
+	paused := s.paused
+	s.mu.Unlock()
+
+	if paused {
+		s.resume(s.debugger.Abort)
+		return
+	}
+
+	s.debugger.Interrupt()
+}
+
+func (s *server) requireLaunched() error {
+	if s.debugger == nil {
+		return errors.New("no program has been launched")
+	}
+
+	return nil
+}
+
+func (s *server) requirePaused() error {
+	err := s.requireLaunched()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.paused {
+		return errors.New("the program is not paused")
+	}
+
+	return nil
+}
+
+func (s *server) respond(req *request, body interface{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	r := response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+
+	if err != nil {
+		r.Message = err.Error()
+	}
+
+	writeMessage(s.out, r)
+}
+
+func (s *server) send(name string, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	writeMessage(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
@@ -0,0 +1,230 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/lexer"
+	"github.com/looplanguage/loop/parser"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type client struct {
+	t   *testing.T
+	in  *io.PipeWriter
+	out *bufio.Reader
+	seq int
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "double.lpx")
+
+	// Constant 1 is the function, constant 0 is the 2 it multiplies by
+	sourceMap := `{
+		"main": [{"offset": 0, "file": "double.lp", "line": 1, "column": 1}],
+		"functions": {"1": [{"offset": 0, "file": "double.lp", "line": 2, "column": 5}]}
+	}`
+
+	err := ioutil.WriteFile(program+".map", []byte(sourceMap), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(path string) (*compiler.Bytecode, error) {
+		if path != program {
+			return nil, fmt.Errorf("unexpected program %q", path)
+		}
+
+		p := parser.Create(lexer.Create("var double = fun(x) { return x * 2 }; double(1) + double(2)"))
+		comp := compiler.Create()
+		err := comp.Compile(p.Parse(), "", "", "")
+
+		return comp.Bytecode(), err
+	}
+
+	c := newClient(t, load)
+
+	c.request("initialize", nil)
+	c.expect("response", "initialize")
+	c.expect("event", "initialized")
+
+	c.request("launch", map[string]interface{}{"program": program})
+	c.expect("response", "launch")
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filepath.Join(dir, "double.lp")},
+		"breakpoints": []map[string]int{{"line": 2}, {"line": 7}},
+	})
+	breakpoints := c.expect("response", "setBreakpoints")["body"].(map[string]interface{})["breakpoints"].([]interface{})
+	if len(breakpoints) != 2 ||
+		breakpoints[0].(map[string]interface{})["verified"] != true ||
+		breakpoints[1].(map[string]interface{})["verified"] != false ||
+		breakpoints[1].(map[string]interface{})["message"] != "no instructions on line 7" {
+		t.Fatalf("wrong breakpoints. got=%v", breakpoints)
+	}
+
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone")
+
+	for _, expected := range []string{"1", "2"} {
+		stopped := c.expect("event", "stopped")
+		if reason := stopped["body"].(map[string]interface{})["reason"]; reason != "breakpoint" {
+			t.Fatalf("wrong stop reason. got=%v", reason)
+		}
+
+		c.request("stackTrace", map[string]int{"threadId": threadId})
+		frames := c.expect("response", "stackTrace")["body"].(map[string]interface{})["stackFrames"].([]interface{})
+		if len(frames) != 2 {
+			t.Fatalf("wrong amount of frames. want=2. got=%d", len(frames))
+		}
+
+		if line := frames[0].(map[string]interface{})["line"]; line != float64(2) {
+			t.Fatalf("wrong line for innermost frame. want=2. got=%v", line)
+		}
+
+		c.request("scopes", map[string]int{"frameId": 0})
+		scopes := c.expect("response", "scopes")["body"].(map[string]interface{})["scopes"].([]interface{})
+		locals := scopes[0].(map[string]interface{})["variablesReference"]
+
+		c.request("variables", map[string]interface{}{"variablesReference": locals})
+		variables := c.expect("response", "variables")["body"].(map[string]interface{})["variables"].([]interface{})
+		if len(variables) != 1 || variables[0].(map[string]interface{})["value"] != expected {
+			t.Fatalf("wrong locals. want=[%s]. got=%v", expected, variables)
+		}
+
+		c.request("continue", map[string]int{"threadId": threadId})
+		c.expect("response", "continue")
+	}
+
+	output := c.expect("event", "output")
+	if text := output["body"].(map[string]interface{})["output"]; text != "6\n" {
+		t.Fatalf("wrong output. got=%q", text)
+	}
+
+	c.expect("event", "exited")
+	c.expect("event", "terminated")
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect")
+}
+
+func TestServe_BreakpointsWithoutSourceMap(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "program.lpx")
+
+	load := func(path string) (*compiler.Bytecode, error) {
+		p := parser.Create(lexer.Create("1 + 2"))
+		comp := compiler.Create()
+		err := comp.Compile(p.Parse(), "", "", "")
+
+		return comp.Bytecode(), err
+	}
+
+	c := newClient(t, load)
+
+	c.request("initialize", nil)
+	c.expect("response", "initialize")
+	c.expect("event", "initialized")
+
+	c.request("launch", map[string]interface{}{"program": program})
+	c.expect("response", "launch")
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "program.lp"},
+		"breakpoints": []map[string]int{{"line": 1}},
+	})
+	breakpoints := c.expect("response", "setBreakpoints")["body"].(map[string]interface{})["breakpoints"].([]interface{})
+	if len(breakpoints) != 1 || breakpoints[0].(map[string]interface{})["verified"] != false ||
+		breakpoints[0].(map[string]interface{})["message"] == nil {
+		t.Fatalf("expected an unverified breakpoint with a message. got=%v", breakpoints)
+	}
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect")
+}
+
+func newClient(t *testing.T, load Loader) *client {
+	requests, requestWriter := io.Pipe()
+	responseReader, responses := io.Pipe()
+
+	go func() {
+		err := Serve(requests, responses, load)
+		if err != nil {
+			t.Errorf("server error: %s", err)
+		}
+
+		responses.Close()
+	}()
+
+	return &client{t: t, in: requestWriter, out: bufio.NewReader(responseReader)}
+}
+
+func (c *client) request(command string, arguments interface{}) {
+	c.seq++
+
+	err := writeMessage(c.in, map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": arguments,
+	})
+	if err != nil {
+		c.t.Fatalf("unable to send %q: %s", command, err)
+	}
+}
+
+// expect reads the next message and fails unless it is the given response or event.
+func (c *client) expect(kind string, name string) map[string]interface{} {
+	c.t.Helper()
+
+	messages := make(chan map[string]interface{})
+
+	go func() {
+		headers, err := textproto.NewReader(c.out).ReadMIMEHeader()
+		if err != nil {
+			close(messages)
+			return
+		}
+
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		body := make([]byte, length)
+		io.ReadFull(c.out, body)
+
+		var message map[string]interface{}
+		json.Unmarshal(body, &message)
+		messages <- message
+	}()
+
+	select {
+	case message, ok := <-messages:
+		if !ok {
+			c.t.Fatalf("connection closed while waiting for %s %q", kind, name)
+		}
+
+		key := "event"
+		if kind == "response" {
+			key = "command"
+		}
+
+		if message["type"] != kind || message[key] != name {
+			c.t.Fatalf("expected %s %q. got=%v", kind, name, message)
+		}
+
+		if kind == "response" && message["success"] != true {
+			c.t.Fatalf("%q failed: %v", name, message["message"])
+		}
+
+		return message
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for %s %q", kind, name)
+	}
+
+	return nil
+}
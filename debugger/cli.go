@@ -0,0 +1,212 @@
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const help = `commands:
+  break <function> <ip>   pause at an instruction, function 0 is the main program
+  break <file>:<line>     pause at a source line (requires a source map)
+  delete <...>            remove a breakpoint, same arguments as break
+  continue, c             run until the next breakpoint
+  step, s                 step into the next line or instruction
+  next, n                 step over calls
+  out, finish             run until the current function returns
+  backtrace, bt           print the call stack
+  stack                   print the operand stack
+  locals [frame]          print the locals of a frame, 0 is the innermost frame
+  free [frame]            print the free variables of a frame
+  globals                 print globals and variables
+  quit, q                 stop the program
+`
+
+// RunCLI drives a debugging session with commands read line by line from in. The program is paused before its first
+// instruction so breakpoints can be set.
+func RunCLI(in io.Reader, out io.Writer, d *Debugger) {
+	scanner := bufio.NewScanner(in)
+
+	printEvent(out, d.Start(context.Background(), true))
+
+	for {
+		io.WriteString(out, "(lpvm) ")
+
+		if !scanner.Scan() {
+			if !d.done {
+				printEvent(out, d.Abort())
+			}
+
+			return
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		command, args := fields[0], fields[1:]
+
+		if d.done && command != "quit" && command != "q" && command != "help" {
+			fmt.Fprintln(out, "the program has finished")
+			continue
+		}
+
+		switch command {
+		case "help", "h":
+			io.WriteString(out, help)
+		case "break", "b", "delete", "d":
+			err := toggleBreakpoint(d, args, command == "break" || command == "b")
+			if err != nil {
+				fmt.Fprintln(out, err)
+			}
+		case "continue", "c":
+			printEvent(out, d.Continue())
+		case "step", "s":
+			printEvent(out, d.StepInto())
+		case "next", "n":
+			printEvent(out, d.StepOver())
+		case "out", "finish":
+			printEvent(out, d.StepOut())
+		case "backtrace", "bt":
+			for i, frame := range d.VM().Backtrace() {
+				fmt.Fprintf(out, "#%d %s\n", i, frame)
+			}
+		case "stack":
+			printValues(out, d.VM().Stack())
+		case "locals", "free":
+			frame, err := frameArgument(args)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+
+			if command == "locals" {
+				printValues(out, d.VM().Locals(frame))
+			} else {
+				printValues(out, d.VM().Free(frame))
+			}
+		case "globals":
+			printStore(out, "global", d.VM().Globals())
+			printStore(out, "var", d.VM().Variables())
+		case "quit", "q":
+			if !d.done {
+				printEvent(out, d.Abort())
+			}
+
+			return
+		default:
+			fmt.Fprintf(out, "unknown command %q, type \"help\" for a list of commands\n", command)
+		}
+	}
+}
+
+func toggleBreakpoint(d *Debugger, args []string, set bool) error {
+	switch len(args) {
+	case 1:
+		separator := strings.LastIndex(args[0], ":")
+		if separator < 0 {
+			return fmt.Errorf("expected <file>:<line>. got=%q", args[0])
+		}
+
+		line, err := strconv.Atoi(args[0][separator+1:])
+		if err != nil {
+			return fmt.Errorf("invalid line. got=%q", args[0][separator+1:])
+		}
+
+		breakpoint := LineBreakpoint{File: args[0][:separator], Line: line}
+		if set {
+			d.SetLineBreakpoint(breakpoint)
+		} else {
+			d.ClearLineBreakpoint(breakpoint)
+		}
+	case 2:
+		function, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid function id. got=%q", args[0])
+		}
+
+		ip, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid instruction offset. got=%q", args[1])
+		}
+
+		breakpoint := Breakpoint{FunctionId: function, Ip: ip}
+		if set {
+			d.SetBreakpoint(breakpoint)
+		} else {
+			d.ClearBreakpoint(breakpoint)
+		}
+	default:
+		return errors.New("usage: break <function> <ip> | break <file>:<line>")
+	}
+
+	return nil
+}
+
+func frameArgument(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	frame, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid frame. got=%q", args[0])
+	}
+
+	return frame, nil
+}
+
+func printEvent(out io.Writer, event Event) {
+	if !event.Done {
+		fmt.Fprintf(out, "paused (%s) at %s: %s\n", event.Reason, event.Frame, vm.OpcodeName(event.OpCode))
+		return
+	}
+
+	if event.Err == nil {
+		fmt.Fprintln(out, "program finished")
+		return
+	}
+
+	var runtimeErr *vm.RuntimeError
+	if errors.As(event.Err, &runtimeErr) && !errors.Is(event.Err, ErrAborted) {
+		io.WriteString(out, runtimeErr.StackTrace())
+		return
+	}
+
+	fmt.Fprintf(out, "program stopped: %s\n", event.Err)
+}
+
+func printValues(out io.Writer, values []object.Object) {
+	for i, value := range values {
+		fmt.Fprintf(out, "  [%d] %s\n", i, inspect(value))
+	}
+}
+
+func printStore(out io.Writer, name string, store map[int]object.Object) {
+	indices := make([]int, 0, len(store))
+	for index := range store {
+		indices = append(indices, index)
+	}
+
+	sort.Ints(indices)
+
+	for _, index := range indices {
+		fmt.Fprintf(out, "  %s %d = %s\n", name, index, inspect(store[index]))
+	}
+}
+
+func inspect(value object.Object) string {
+	if value == nil {
+		return "<unset>"
+	}
+
+	return value.Inspect()
+}
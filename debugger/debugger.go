@@ -0,0 +1,313 @@
+package debugger
+
+import (
+	"context"
+	"errors"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/lpvm/vm"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrAborted = errors.New("debugging session aborted")
+
+type StopReason string
+
+const (
+	StopEntry      StopReason = "entry"
+	StopBreakpoint StopReason = "breakpoint"
+	StopStep       StopReason = "step"
+	StopPause      StopReason = "pause"
+)
+
+// Breakpoint is an instruction offset in a function, function id 0 is the main program.
+type Breakpoint struct {
+	FunctionId int
+	Ip         int
+}
+
+type LineBreakpoint struct {
+	File string
+	Line int
+}
+
+// Event is sent whenever the program pauses or finishes. Frame and OpCode describe the instruction that is about
+// to be executed, Err holds the result of Run once Done is set.
+type Event struct {
+	Reason StopReason
+	Frame  vm.StackFrame
+	OpCode code.OpCode
+
+	Done bool
+	Err  error
+}
+
+type stepMode int
+
+const (
+	modeContinue stepMode = iota
+	modeStepInto
+	modeStepOver
+	modeStepOut
+	modeAbort
+)
+
+type location struct {
+	depth    int
+	position vm.SourcePosition
+	known    bool
+}
+
+// Debugger runs a VM on its own goroutine, pausing it on breakpoints and steps. Its methods are meant to be called
+// from a single controlling goroutine, the VM is only inspected while it is paused.
+type Debugger struct {
+	machine *vm.VM
+
+	mu              sync.Mutex
+	breakpoints     map[Breakpoint]bool
+	lineBreakpoints map[LineBreakpoint]bool
+
+	mode     stepMode
+	from     location
+	previous location
+
+	resume  chan stepMode
+	events  chan Event
+	running bool
+	done    bool
+
+	pauseRequested     int32
+	interruptRequested int32
+}
+
+func New(machine *vm.VM) *Debugger {
+	return &Debugger{
+		machine:         machine,
+		breakpoints:     map[Breakpoint]bool{},
+		lineBreakpoints: map[LineBreakpoint]bool{},
+		resume:          make(chan stepMode),
+		events:          make(chan Event),
+	}
+}
+
+func (d *Debugger) VM() *vm.VM {
+	return d.machine
+}
+
+func (d *Debugger) SetBreakpoint(breakpoint Breakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints[breakpoint] = true
+}
+
+func (d *Debugger) ClearBreakpoint(breakpoint Breakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.breakpoints, breakpoint)
+}
+
+// SetLineBreakpoint pauses on the first instruction of a source line, it requires a source map on the VM.
+func (d *Debugger) SetLineBreakpoint(breakpoint LineBreakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lineBreakpoints[breakpoint] = true
+}
+
+func (d *Debugger) ClearLineBreakpoint(breakpoint LineBreakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.lineBreakpoints, breakpoint)
+}
+
+// ClearLineBreakpoints removes every line breakpoint in a file.
+func (d *Debugger) ClearLineBreakpoints(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for breakpoint := range d.lineBreakpoints {
+		if breakpoint.File == file {
+			delete(d.lineBreakpoints, breakpoint)
+		}
+	}
+}
+
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var breakpoints []Breakpoint
+	for breakpoint := range d.breakpoints {
+		breakpoints = append(breakpoints, breakpoint)
+	}
+
+	return breakpoints
+}
+
+// Start begins executing the program and blocks until it first pauses or finishes. With stopOnEntry the program
+// pauses before its first instruction.
+func (d *Debugger) Start(ctx context.Context, stopOnEntry bool) Event {
+	if d.running {
+		return Event{Done: d.done}
+	}
+
+	d.running = true
+
+	if stopOnEntry {
+		d.mode = modeStepInto
+	}
+
+	d.machine.SetInstructionHook(d.onInstruction)
+
+	go func() {
+		err := d.machine.RunContext(ctx, nil)
+		d.events <- Event{Done: true, Err: err}
+	}()
+
+	return d.wait()
+}
+
+func (d *Debugger) Continue() Event {
+	return d.step(modeContinue)
+}
+
+// StepInto pauses on the next line, or the next instruction without a source map, following calls.
+func (d *Debugger) StepInto() Event {
+	return d.step(modeStepInto)
+}
+
+// StepOver pauses on the next line in the current function, or any function it returns to.
+func (d *Debugger) StepOver() Event {
+	return d.step(modeStepOver)
+}
+
+// StepOut pauses once the current function has returned.
+func (d *Debugger) StepOut() Event {
+	return d.step(modeStepOut)
+}
+
+// Abort stops the program, the returned event holds ErrAborted.
+func (d *Debugger) Abort() Event {
+	return d.step(modeAbort)
+}
+
+// Pause asks a running program to pause before its next instruction, the pending Continue or step call returns with
+// StopPause. Unlike the other methods it is safe to call from any goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pauseRequested, 1)
+}
+
+// Interrupt stops a running program without waiting for it to pause, the pending Continue or step call returns
+// with ErrAborted. It is safe to call from any goroutine.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interruptRequested, 1)
+}
+
+func (d *Debugger) step(mode stepMode) Event {
+	if !d.running || d.done {
+		return Event{Done: true}
+	}
+
+	d.resume <- mode
+
+	return d.wait()
+}
+
+func (d *Debugger) wait() Event {
+	event := <-d.events
+
+	if event.Done {
+		d.done = true
+		d.machine.SetInstructionHook(nil)
+	}
+
+	return event
+}
+
+func (d *Debugger) current() location {
+	fn, ip := d.machine.Location()
+	position, known := d.machine.Position(fn, ip)
+
+	return location{depth: d.machine.Depth(), position: position, known: known}
+}
+
+// onInstruction runs on the VM goroutine before every instruction and blocks while the program is paused.
+func (d *Debugger) onInstruction(op code.OpCode) error {
+	if atomic.LoadInt32(&d.interruptRequested) == 1 {
+		return ErrAborted
+	}
+
+	here := d.current()
+	previous := d.previous
+	d.previous = here
+
+	reason, stop := d.shouldStop(here, previous)
+	if !stop {
+		return nil
+	}
+
+	d.events <- Event{Reason: reason, Frame: d.machine.Backtrace()[0], OpCode: op}
+
+	d.mode = <-d.resume
+	d.from = d.current()
+
+	if d.mode == modeAbort {
+		return ErrAborted
+	}
+
+	return nil
+}
+
+func (d *Debugger) shouldStop(here location, previous location) (StopReason, bool) {
+	if atomic.CompareAndSwapInt32(&d.pauseRequested, 1, 0) {
+		return StopPause, true
+	}
+
+	// Line based stepping only pauses once execution reaches a different line than where it started
+	newLine := !here.known || here.position != d.from.position || here.depth != d.from.depth
+
+	switch d.mode {
+	case modeStepInto:
+		// Nothing has executed yet when stopping on entry
+		if d.from.depth == 0 {
+			return StopEntry, true
+		}
+
+		if newLine {
+			return StopStep, true
+		}
+	case modeStepOver:
+		if here.depth < d.from.depth || (here.depth == d.from.depth && newLine) {
+			return StopStep, true
+		}
+	case modeStepOut:
+		if here.depth < d.from.depth {
+			return StopStep, true
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fn, ip := d.machine.Location()
+	id := 0
+	if fn != nil {
+		id = d.machine.FunctionId(fn)
+	}
+
+	if d.breakpoints[Breakpoint{FunctionId: id, Ip: ip}] {
+		return StopBreakpoint, true
+	}
+
+	// A line breakpoint only triggers when entering the line, not for each of its instructions
+	if here.known && (here.position != previous.position || here.depth != previous.depth) {
+		if d.lineBreakpoints[LineBreakpoint{File: here.position.File, Line: here.position.Line}] {
+			return StopBreakpoint, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,154 @@
+package debugger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/lexer"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/loop/parser"
+	"github.com/looplanguage/lpvm/vm"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const program = "var double = fun(x) { return x * 2 }; double(1) + double(2)"
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	machine, fn := create(t, program)
+	d := New(machine)
+	d.SetBreakpoint(Breakpoint{FunctionId: machine.FunctionId(fn), Ip: 0})
+
+	event := d.Start(context.Background(), false)
+
+	for i, expected := range []int64{1, 2} {
+		if i > 0 {
+			event = d.Continue()
+		}
+
+		if event.Done || event.Reason != StopBreakpoint {
+			t.Fatalf("expected breakpoint. got=%+v", event)
+		}
+
+		if event.Frame.FunctionId != machine.FunctionId(fn) || event.Frame.Ip != 0 {
+			t.Fatalf("paused at wrong location. got=%s", event.Frame)
+		}
+
+		locals := d.VM().Locals(0)
+		if len(locals) != 1 || locals[0].(*object.Integer).Value != expected {
+			t.Fatalf("wrong locals. want=[%d]. got=%v", expected, locals)
+		}
+	}
+
+	event = d.Continue()
+	if !event.Done || event.Err != nil {
+		t.Fatalf("expected program to finish. got=%+v", event)
+	}
+
+	result, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || result.Value != 6 {
+		t.Fatalf("wrong result. got=%v", machine.LastPoppedStackElem())
+	}
+}
+
+func TestDebugger_Stepping(t *testing.T) {
+	machine, fn := create(t, program)
+	d := New(machine)
+
+	event := d.Start(context.Background(), true)
+	if event.Reason != StopEntry || event.Frame.Function != nil || event.Frame.Ip != 0 {
+		t.Fatalf("expected to pause on entry. got=%+v", event)
+	}
+
+	event = d.StepInto()
+	if event.Reason != StopStep || event.Frame.Function != nil || event.Frame.Ip == 0 {
+		t.Fatalf("expected to step to the next instruction. got=%+v", event)
+	}
+
+	// Step until the first call enters the function
+	for event.Frame.Function == nil {
+		event = d.StepInto()
+		if event.Done {
+			t.Fatalf("program finished before entering the function")
+		}
+	}
+
+	if event.Frame.FunctionId != machine.FunctionId(fn) || machine.Depth() != 2 {
+		t.Fatalf("expected to step into the function. got=%s", event.Frame)
+	}
+
+	event = d.StepOut()
+	if event.Done || event.Frame.Function != nil || machine.Depth() != 1 {
+		t.Fatalf("expected to step out to main. got=%+v", event)
+	}
+
+	// Stepping over the second call never pauses inside the function
+	for !event.Done {
+		event = d.StepOver()
+		if !event.Done && event.Frame.Function != nil {
+			t.Fatalf("stepped into the function while stepping over. got=%s", event.Frame)
+		}
+	}
+}
+
+func TestDebugger_Abort(t *testing.T) {
+	machine, _ := create(t, program)
+	d := New(machine)
+
+	d.Start(context.Background(), true)
+
+	event := d.Abort()
+	if !event.Done || !errors.Is(event.Err, ErrAborted) {
+		t.Fatalf("expected aborted program. got=%+v", event)
+	}
+}
+
+func TestRunCLI(t *testing.T) {
+	machine, fn := create(t, program)
+
+	input := strings.Join([]string{
+		"break " + strconv.Itoa(machine.FunctionId(fn)) + " 0",
+		"continue",
+		"locals",
+		"bt",
+		"continue",
+		"locals",
+		"continue",
+		"continue",
+		"quit",
+	}, "\n")
+
+	var out bytes.Buffer
+	RunCLI(strings.NewReader(input), &out, New(machine))
+
+	for _, expected := range []string{"paused (entry)", "paused (breakpoint)", "[0] 1", "[0] 2", "#1 main", "program finished"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q.\n%s", expected, out.String())
+		}
+	}
+}
+
+func create(t *testing.T, input string) (*vm.VM, *object.CompiledFunction) {
+	t.Helper()
+
+	p := parser.Create(lexer.Create(input))
+	comp := compiler.Create()
+	err := comp.Compile(p.Parse(), "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+	machine := vm.Create(bytecode)
+
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			return machine, fn
+		}
+	}
+
+	t.Fatalf("program has no function")
+	return nil, nil
+}
@@ -0,0 +1,155 @@
+package disasm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"io"
+)
+
+type disassembler struct {
+	out       io.Writer
+	constants []object.Object
+	ids       map[*object.CompiledFunction]int
+	printed   map[int]bool
+}
+
+// Fprint writes the instructions of the main program to out, followed by every compiled function it references.
+// Function ids match the ones the VM uses in backtraces.
+func Fprint(out io.Writer, bytecode *compiler.Bytecode) {
+	FprintSince(out, bytecode, 0, 0)
+}
+
+// FprintSince is like Fprint, but skips the instructions of the main program before offset ip and only lists the
+// unreferenced functions from the given constant index on. The REPL uses it to show what a single input compiled to.
+func FprintSince(out io.Writer, bytecode *compiler.Bytecode, ip int, constant int) {
+	d := &disassembler{
+		out:       out,
+		constants: bytecode.Constants,
+		ids:       vm.FunctionIds(bytecode.Constants),
+		printed:   map[int]bool{},
+	}
+
+	fmt.Fprintln(out, "main:")
+	d.instructions(bytecode.Instructions, ip)
+
+	// Functions that aren't referenced anywhere still get listed, in order of the constant pool
+	for i := constant; i < len(bytecode.Constants); i++ {
+		if _, ok := bytecode.Constants[i].(*object.CompiledFunction); ok {
+			d.function(i)
+		}
+	}
+}
+
+func (d *disassembler) function(index int) {
+	if d.printed[index] {
+		return
+	}
+
+	d.printed[index] = true
+	fn := d.constants[index].(*object.CompiledFunction)
+
+	fmt.Fprintf(d.out, "\nfunction %d (constant %d, parameters=%d, locals=%d):\n", d.ids[fn], index, fn.NumParameters, fn.NumLocals)
+	d.instructions(fn.Instructions, 0)
+}
+
+func (d *disassembler) instructions(ins code.Instructions, start int) {
+	var functions []int
+
+	for ip := start; ip < len(ins); {
+		op := code.OpCode(ins[ip])
+
+		def, err := vm.Lookup(byte(op))
+		if err != nil {
+			fmt.Fprintf(d.out, "  %04d <unknown opcode %d>\n", ip, op)
+			ip++
+			continue
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+
+		if ip+1+width > len(ins) {
+			fmt.Fprintf(d.out, "  %04d %s <truncated>\n", ip, vm.OpcodeName(op))
+			break
+		}
+
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		line := fmt.Sprintf("  %04d %s", ip, vm.OpcodeName(op))
+		for _, operand := range operands {
+			line += fmt.Sprintf(" %d", operand)
+		}
+
+		if comment := d.comment(op, operands); comment != "" {
+			line += fmt.Sprintf("\t; %s", comment)
+		}
+
+		fmt.Fprintln(d.out, line)
+
+		if index, ok := d.functionOperand(op, operands); ok {
+			functions = append(functions, index)
+		}
+
+		ip += 1 + read
+	}
+
+	for _, index := range functions {
+		d.function(index)
+	}
+}
+
+func (d *disassembler) comment(op code.OpCode, operands []int) string {
+	switch op {
+	case code.OpConstant:
+		if operands[0] >= len(d.constants) {
+			return "<constant out of range>"
+		}
+
+		if fn, ok := d.constants[operands[0]].(*object.CompiledFunction); ok {
+			return fmt.Sprintf("function %d", d.ids[fn])
+		}
+
+		return d.constants[operands[0]].Inspect()
+	case code.OpClosure:
+		if operands[0] >= len(d.constants) {
+			return "<constant out of range>"
+		}
+
+		fn, ok := d.constants[operands[0]].(*object.CompiledFunction)
+		if !ok {
+			return "<not a function>"
+		}
+
+		return fmt.Sprintf("function %d, free=%d", d.ids[fn], operands[1])
+	case code.OpJump, code.OpJumpIfNotTrue:
+		return fmt.Sprintf("-> %04d", operands[0])
+	case code.OpGetBuiltinFunction:
+		if operands[0] >= len(object.Builtins) {
+			return "<unknown builtin>"
+		}
+
+		return object.Builtins[operands[0]].Name
+	case code.OpCall:
+		return fmt.Sprintf("args=%d", operands[0])
+	}
+
+	return ""
+}
+
+func (d *disassembler) functionOperand(op code.OpCode, operands []int) (int, bool) {
+	if op != code.OpConstant && op != code.OpClosure {
+		return 0, false
+	}
+
+	if operands[0] >= len(d.constants) {
+		return 0, false
+	}
+
+	_, ok := d.constants[operands[0]].(*object.CompiledFunction)
+	return operands[0], ok
+}
@@ -0,0 +1,62 @@
+package disasm
+
+import (
+	"bytes"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions: concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpReturnValue),
+		),
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: concat(
+			code.Make(code.OpClosure, 1, 0),
+			code.Make(code.OpCall, 0),
+			code.Make(code.OpJumpIfNotTrue, 12),
+			code.Make(code.OpGetBuiltinFunction, 0),
+			code.Make(code.OpPop),
+			[]byte{byte(vm.OpModulo)},
+			[]byte{255},
+		),
+		Constants: []object.Object{&object.Integer{Value: 5}, fn},
+	}
+
+	expected := "main:\n" +
+		"  0000 OpClosure 1 0\t; function 2, free=0\n" +
+		"  0004 OpCall 0\t; args=0\n" +
+		"  0006 OpJumpIfNotTrue 12\t; -> 0012\n" +
+		"  0009 OpGetBuiltinFunction 0\t; " + object.Builtins[0].Name + "\n" +
+		"  0011 OpPop\n" +
+		"  0012 OpModulo\n" +
+		"  0013 <unknown opcode 255>\n" +
+		"\n" +
+		"function 2 (constant 1, parameters=0, locals=0):\n" +
+		"  0000 OpConstant 0\t; 5\n" +
+		"  0003 OpReturnValue\n"
+
+	var out bytes.Buffer
+	Fprint(&out, bytecode)
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\ngot=%q\nwant=%q", out.String(), expected)
+	}
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+
+	return out
+}
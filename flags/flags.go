@@ -3,21 +3,66 @@ package flags
 import (
 	"flag"
 	"strings"
+	"time"
 )
 
 var Optimizations = map[string]bool{}
 var File string
+var Command string
+var Timeout time.Duration
+var Fuel uint64
+var SourceMap string
+var Profile bool
+var CPUProfile string
+var Trace string
+var TraceSample uint64 = 1
+var MemoEntries int
+var MemoBytes int
+var MemoPolicy = "lru"
+var Overflow = "wrap"
+var Session string
+
+var optimizations string
+
+// define registers the flags on the given set, using their current values as defaults
+func define(set *flag.FlagSet) {
+	set.StringVar(&optimizations, "o", optimizations, "Specify which VM optimizations you'd like to activate. Seperated by a comma")
+	set.DurationVar(&Timeout, "timeout", Timeout, "Stop execution after the given duration (e.g. 5s). Zero means no limit")
+	set.StringVar(&SourceMap, "sourcemap", SourceMap, "Source map of the bytecode file, defaults to the file name with \".map\" appended when it exists")
+	set.Uint64Var(&Fuel, "fuel", Fuel, "Stop execution once the instruction fuel is exhausted. Zero means no limit")
+	set.StringVar(&CPUProfile, "cpuprofile", CPUProfile, "Write a pprof CPU profile of the Loop call stacks to the given file")
+	set.StringVar(&Trace, "trace", Trace, "Write the function calls as a Chrome trace (chrome://tracing, Perfetto) to the given file")
+	set.Uint64Var(&TraceSample, "tracesample", TraceSample, "Only trace one in every N function calls")
+	set.IntVar(&MemoEntries, "memoentries", MemoEntries, "Maximum amount of results kept by the memoize optimization. Zero means no limit")
+	set.IntVar(&MemoBytes, "memobytes", MemoBytes, "Maximum estimated size in bytes of the results kept by the memoize optimization. Zero means no limit")
+	set.StringVar(&MemoPolicy, "memopolicy", MemoPolicy, "Which results the memoize optimization evicts first when it is full, \"lru\" or \"lfu\"")
+	set.StringVar(&Overflow, "overflow", Overflow, "What happens when integer arithmetic overflows: \"wrap\", \"checked\" (runtime error) or \"promote\" (big integers)")
+	set.BoolVar(&Profile, "profile", Profile, "Print the time spent per opcode, function and call site to stderr at exit")
+	set.StringVar(&Session, "session", Session, "REPL session file, restored at start when it exists and saved at exit")
+}
 
 func Parse() {
-	optimizationsFlag := flag.String("o", "", "Specify which VM optimizations you'd like to activate. Seperated by a comma")
+	define(flag.CommandLine)
 
 	flag.Parse()
 
-	File = flag.Arg(0)
+	// Subcommands come before the file, e.g. "lpvm disasm program.lpx"
+	switch flag.Arg(0) {
+	case "disasm", "debug", "dap", "purity":
+		Command = flag.Arg(0)
+		File = flag.Arg(1)
+	case "repl":
+		Command = flag.Arg(0)
 
-	optimizations := strings.Split(*optimizationsFlag, ",")
+		// All flags may also follow the subcommand, e.g. "lpvm repl -session work.lps -o memoize"
+		replFlags := flag.NewFlagSet("repl", flag.ExitOnError)
+		define(replFlags)
+		replFlags.Parse(flag.Args()[1:])
+	default:
+		File = flag.Arg(0)
+	}
 
-	for _, optimization := range optimizations {
+	for _, optimization := range strings.Split(optimizations, ",") {
 		Optimizations[optimization] = true
 	}
 }
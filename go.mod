@@ -5,4 +5,7 @@ go 1.17
 require (
 	github.com/looplanguage/compiler v0.5.0
 	github.com/looplanguage/loop v0.7.0
+	golang.org/x/term v0.10.0
 )
+
+require golang.org/x/sys v0.10.0 // indirect
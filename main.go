@@ -2,30 +2,222 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/lpvm/dap"
+	"github.com/looplanguage/lpvm/debugger"
+	"github.com/looplanguage/lpvm/disasm"
 	"github.com/looplanguage/lpvm/flags"
+	"github.com/looplanguage/lpvm/pprof"
+	"github.com/looplanguage/lpvm/pretty"
 	"github.com/looplanguage/lpvm/repl"
 	"github.com/looplanguage/lpvm/vm"
+	"golang.org/x/term"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
 	flags.Parse()
 
+	switch flags.Command {
+	case "disasm":
+		if flags.File == "" {
+			log.Fatalln("usage: lpvm disasm <file>")
+		}
+
+		disasm.Fprint(os.Stdout, loadBytecode(flags.File))
+		return
+	case "purity":
+		if flags.File == "" {
+			log.Fatalln("usage: lpvm purity <file>")
+		}
+
+		vm.AnalyzePurity(loadBytecode(flags.File)).Fprint(os.Stdout)
+		return
+	case "debug":
+		if flags.File == "" {
+			log.Fatalln("usage: lpvm debug <file>")
+		}
+
+		debugger.RunCLI(os.Stdin, os.Stdout, debugger.New(createMachine()))
+		return
+	case "dap":
+		err := dap.Serve(os.Stdin, os.Stdout, readBytecode)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	case "repl":
+		startRepl()
+		return
+	}
+
 	if flags.File == "" {
-		repl.Start(os.Stdin, os.Stdout)
+		startRepl()
 		return
 	}
 
+	machine := createMachine()
+
+	if flags.Fuel > 0 {
+		machine.SetFuel(flags.Fuel)
+	}
+
+	if flags.Profile {
+		machine.EnableProfiling()
+	}
+
+	if flags.CPUProfile != "" {
+		machine.StartCPUProfile(10 * time.Millisecond)
+	}
+
+	if flags.Trace != "" {
+		machine.StartTrace(flags.TraceSample)
+	}
+
+	ctx := context.Background()
+
+	if flags.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.Timeout)
+		defer cancel()
+	}
+
+	err := machine.RunContext(ctx, nil)
+
+	if flags.Profile {
+		machine.Profile().Fprint(os.Stderr)
+	}
+
+	if flags.CPUProfile != "" {
+		writeCPUProfile(flags.CPUProfile, machine.StopCPUProfile())
+	}
+
+	if flags.Trace != "" {
+		writeTrace(flags.Trace, machine.StopTrace())
+	}
+
+	if err != nil {
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			log.Fatal(runtimeErr.StackTrace())
+		}
+
+		log.Fatal(err)
+	}
+
+	// Output which is piped somewhere else is printed as is, truncating or quoting it would change the result
+	if result := machine.LastPoppedStackElem(); result != nil {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			fmt.Println(pretty.Sprint(result, prettyOptions()))
+		} else {
+			fmt.Println(result.Inspect())
+		}
+	}
+}
+
+// prettyOptions colors values when stdout is a terminal, unless NO_COLOR is set.
+func prettyOptions() pretty.Options {
+	options := pretty.Default
+	options.Colors = term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("NO_COLOR") == ""
+
+	return options
+}
+
+// createMachine loads, verifies and prepares the bytecode file for execution.
+func createMachine() *vm.VM {
+	bytecode := loadBytecode(flags.File)
+
+	err := vm.Verify(bytecode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	machine := vm.Create(bytecode)
+
+	switch flags.Overflow {
+	case "wrap":
+		machine.SetIntegerMode(vm.IntegerWrap)
+	case "checked":
+		machine.SetIntegerMode(vm.IntegerChecked)
+	case "promote":
+		machine.SetIntegerMode(vm.IntegerPromote)
+	default:
+		log.Fatalf("unknown overflow mode %q", flags.Overflow)
+	}
+
+	if cache := memoCache(); cache != nil {
+		machine.SetMemoCache(cache)
+	}
+
+	sourceMap, err := loadSourceMap()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	machine.SetSourceMap(sourceMap)
+
+	return machine
+}
+
+// memoCache creates the cache configured by the memo flags, or returns nil when the memoize optimization is disabled.
+func memoCache() *vm.MemoCache {
+	if !flags.OptimizationEnabled("memoize") {
+		return nil
+	}
+
+	options := vm.MemoCacheOptions{MaxEntries: flags.MemoEntries, MaxBytes: flags.MemoBytes}
+
+	switch flags.MemoPolicy {
+	case "lru":
+		options.Policy = vm.EvictLRU
+	case "lfu":
+		options.Policy = vm.EvictLFU
+	default:
+		log.Fatalf("unknown memoization policy %q", flags.MemoPolicy)
+	}
+
+	return vm.NewBoundedMemoCache(options)
+}
+
+func startRepl() {
+	options := prettyOptions()
+
+	err := repl.StartWithOptions(os.Stdin, os.Stdout, repl.Options{
+		Err:       os.Stderr,
+		Pretty:    &options,
+		Session:   flags.Session,
+		MemoCache: memoCache(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadBytecode(path string) *compiler.Bytecode {
+	bytecode, err := readBytecode(path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return bytecode
+}
+
+func readBytecode(path string) (*compiler.Bytecode, error) {
 	compiler.RegisterGobTypes()
-	bts, err := ioutil.ReadFile(flags.File)
+	vm.RegisterGobTypes()
+	bts, err := ioutil.ReadFile(path)
 
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	var constantBytes bytes.Buffer
@@ -35,18 +227,57 @@ func main() {
 	var Bytecode compiler.Bytecode
 	err = dec.Decode(&Bytecode)
 
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bytecode, nil
+}
+
+// loadSourceMap reads the source map given by -sourcemap, or the one next to the bytecode file. Without either the
+// VM simply reports instruction offsets.
+func loadSourceMap() (*vm.SourceMap, error) {
+	path := flags.SourceMap
+
+	if path == "" {
+		path = flags.File + ".map"
+
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return vm.LoadSourceMap(file)
+}
+
+func writeCPUProfile(path string, profile *vm.CPUProfile) {
+	file, err := os.Create(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer file.Close()
 
-	machine := vm.Create(&Bytecode)
-	err = machine.Run(nil)
+	err = pprof.Write(file, profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
+func writeTrace(path string, trace *vm.Trace) {
+	file, err := os.Create(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer file.Close()
 
-	if machine.LastPoppedStackElem() != nil {
-		fmt.Println(machine.LastPoppedStackElem().Inspect())
+	err = trace.WriteJSON(file)
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,150 @@
+// Package pprof writes Loop CPU profiles in the protobuf format read by "go tool pprof".
+package pprof
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/looplanguage/lpvm/vm"
+	"io"
+)
+
+type functionKey struct {
+	id   int
+	file string
+}
+
+type locationKey struct {
+	function uint64
+	ip       int
+	line     int
+}
+
+type encoder struct {
+	profile buffer
+
+	strings   map[string]int64
+	functions map[functionKey]uint64
+	locations map[locationKey]uint64
+}
+
+// Write encodes the profile as a gzipped pprof protobuf. Every Loop frame becomes a location whose address is the
+// instruction offset, source lines are included when the VM had a source map.
+func Write(w io.Writer, profile *vm.CPUProfile) error {
+	e := &encoder{
+		strings:   map[string]int64{},
+		functions: map[functionKey]uint64{},
+		locations: map[locationKey]uint64{},
+	}
+
+	// The string table has to start with the empty string
+	e.string("")
+
+	e.valueType(1, "samples", "count")
+	e.valueType(1, "cpu", "nanoseconds")
+
+	for _, sample := range profile.Samples {
+		e.sample(sample, int64(profile.Interval))
+	}
+
+	e.profile.int64(9, profile.Start.UnixNano())
+	e.profile.int64(10, int64(profile.Duration))
+	e.valueType(11, "cpu", "nanoseconds")
+	e.profile.int64(12, int64(profile.Interval))
+
+	gz := gzip.NewWriter(w)
+
+	_, err := gz.Write(e.profile.data)
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func (e *encoder) string(s string) int64 {
+	index, ok := e.strings[s]
+	if !ok {
+		index = int64(len(e.strings))
+		e.strings[s] = index
+		e.profile.string(6, s)
+	}
+
+	return index
+}
+
+func (e *encoder) valueType(field int, typ string, unit string) {
+	var valueType buffer
+	valueType.int64(1, e.string(typ))
+	valueType.int64(2, e.string(unit))
+
+	e.profile.message(field, &valueType)
+}
+
+func (e *encoder) sample(sample vm.CPUSample, interval int64) {
+	locations := make([]uint64, len(sample.Stack))
+	for i, frame := range sample.Stack {
+		locations[i] = e.location(frame)
+	}
+
+	var s buffer
+	s.packedUint64(1, locations)
+	s.packedInt64(2, []int64{sample.Count, sample.Count * interval})
+
+	e.profile.message(2, &s)
+}
+
+func (e *encoder) location(frame vm.StackFrame) uint64 {
+	file := ""
+	line := 0
+
+	if frame.Position != nil {
+		file = frame.Position.File
+		line = frame.Position.Line
+	}
+
+	key := locationKey{function: e.function(frame, file), ip: frame.Ip, line: line}
+
+	id, ok := e.locations[key]
+	if !ok {
+		id = uint64(len(e.locations) + 1)
+		e.locations[key] = id
+
+		var l buffer
+		l.uint64(1, key.function)
+		l.int64(2, int64(line))
+
+		var location buffer
+		location.uint64(1, id)
+		location.uint64(3, uint64(frame.Ip))
+		location.message(4, &l)
+
+		e.profile.message(4, &location)
+	}
+
+	return id
+}
+
+func (e *encoder) function(frame vm.StackFrame, file string) uint64 {
+	key := functionKey{id: frame.FunctionId, file: file}
+
+	id, ok := e.functions[key]
+	if !ok {
+		id = uint64(len(e.functions) + 1)
+		e.functions[key] = id
+
+		name := "main"
+		if frame.Function != nil {
+			name = fmt.Sprintf("function %d", frame.FunctionId)
+		}
+
+		var function buffer
+		function.uint64(1, id)
+		function.int64(2, e.string(name))
+		function.int64(3, e.string(name))
+		function.int64(4, e.string(file))
+
+		e.profile.message(5, &function)
+	}
+
+	return id
+}
@@ -0,0 +1,130 @@
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	function := &object.CompiledFunction{Id: 3}
+
+	profile := &vm.CPUProfile{
+		Start:    time.Unix(0, 1000),
+		Duration: 30 * time.Millisecond,
+		Interval: 10 * time.Millisecond,
+		Samples: []vm.CPUSample{
+			{
+				Stack: []vm.StackFrame{
+					{FunctionId: 3, Ip: 4, Function: function, Position: &vm.SourcePosition{File: "fib.lp", Line: 2, Column: 5}},
+					{FunctionId: 0, Ip: 10},
+				},
+				Count: 2,
+			},
+			{
+				Stack: []vm.StackFrame{{FunctionId: 0, Ip: 10}},
+				Count: 1,
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	err := Write(&out, profile)
+	if err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("output is not gzipped: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("output is not gzipped: %s", err)
+	}
+
+	fields := decode(t, data)
+
+	expectedStrings := []string{"", "samples", "count", "cpu", "nanoseconds", "function 3", "fib.lp", "main"}
+	if len(fields[6]) != len(expectedStrings) {
+		t.Fatalf("wrong string table. want=%q. got=%q", expectedStrings, fields[6])
+	}
+
+	for i, s := range expectedStrings {
+		if string(fields[6][i].([]byte)) != s {
+			t.Errorf("wrong string at %d. want=%q. got=%q", i, s, fields[6][i])
+		}
+	}
+
+	if len(fields[2]) != 2 || len(fields[4]) != 2 || len(fields[5]) != 2 {
+		t.Fatalf("wrong amount of samples, locations or functions. got=%d, %d, %d", len(fields[2]), len(fields[4]), len(fields[5]))
+	}
+
+	sample := decode(t, fields[2][0].([]byte))
+	if !bytes.Equal(sample[1][0].([]byte), []byte{1, 2}) {
+		t.Errorf("wrong location ids. got=%v", sample[1][0])
+	}
+
+	// 2 samples of 10ms, 20000000 nanoseconds encoded as a varint
+	if !bytes.Equal(sample[2][0].([]byte), []byte{2, 0x80, 0xDA, 0xC4, 0x09}) {
+		t.Errorf("wrong values. got=%v", sample[2][0])
+	}
+
+	location := decode(t, fields[4][0].([]byte))
+	if location[3][0].(uint64) != 4 {
+		t.Errorf("wrong address. want=4. got=%d", location[3][0])
+	}
+
+	line := decode(t, location[4][0].([]byte))
+	if line[2][0].(uint64) != 2 {
+		t.Errorf("wrong line. want=2. got=%d", line[2][0])
+	}
+
+	if fields[12][0].(uint64) != uint64(10*time.Millisecond) {
+		t.Errorf("wrong period. got=%d", fields[12][0])
+	}
+}
+
+// decode splits a protobuf message into its fields, varints are returned as uint64 and everything else as []byte.
+func decode(t *testing.T, data []byte) map[int][]interface{} {
+	fields := map[int][]interface{}{}
+
+	for len(data) > 0 {
+		key, n := varint(data)
+		data = data[n:]
+
+		value, n := varint(data)
+		data = data[n:]
+
+		switch key & 7 {
+		case 0:
+			fields[int(key>>3)] = append(fields[int(key>>3)], value)
+		case 2:
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:value])
+			data = data[value:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+
+	return fields
+}
+
+func varint(data []byte) (uint64, int) {
+	var x uint64
+
+	for i, b := range data {
+		x |= uint64(b&0x7F) << (7 * i)
+
+		if b < 0x80 {
+			return x, i + 1
+		}
+	}
+
+	return x, len(data)
+}
@@ -0,0 +1,68 @@
+package pprof
+
+// buffer is a minimal protocol buffer encoder, it only supports the wire types used by profile.proto.
+type buffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+
+	b.data = append(b.data, byte(x))
+}
+
+func (b *buffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64 writes a scalar field, zero values are omitted like proto3 does.
+func (b *buffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+
+	b.key(field, wireVarint)
+	b.varint(x)
+}
+
+func (b *buffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *buffer) string(field int, s string) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *buffer) packedUint64(field int, xs []uint64) {
+	var packed buffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+
+	b.message(field, &packed)
+}
+
+func (b *buffer) packedInt64(field int, xs []int64) {
+	var packed buffer
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+
+	b.message(field, &packed)
+}
+
+func (b *buffer) message(field int, m *buffer) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(m.data)))
+	b.data = append(b.data, m.data...)
+}
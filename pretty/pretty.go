@@ -0,0 +1,195 @@
+package pretty
+
+import (
+	"fmt"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Options configures how values are printed. The zero value prints values on a single line, without limits and
+// without colors.
+type Options struct {
+	// Indent is repeated once per level of nesting when a collection is spread over several lines.
+	Indent string
+	// Width is the length of the lines collections have to fit on to stay on a single line, zero keeps every
+	// collection on a single line.
+	Width int
+	// MaxDepth replaces collections nested deeper than this with [...] or {...}, zero means no limit.
+	MaxDepth int
+	// MaxElements is the amount of elements printed of a collection before the rest is only counted, zero means no
+	// limit.
+	MaxElements int
+	// Colors colors values by their type with ANSI escape codes.
+	Colors bool
+}
+
+// Default is how the REPL prints results.
+var Default = Options{Indent: "  ", Width: 80, MaxDepth: 6, MaxElements: 100}
+
+// ANSI colors of the value types
+const (
+	colorNumber   = "33"
+	colorString   = "32"
+	colorBoolean  = "35"
+	colorNull     = "90"
+	colorFunction = "36"
+	colorOmitted  = "90"
+)
+
+type printer struct {
+	options Options
+	// visiting holds the collections which are being printed, finding one of them again means the value is cyclic
+	visiting map[object.Object]bool
+}
+
+// Fprint writes a value to out, see Sprint.
+func Fprint(out io.Writer, value object.Object, options Options) {
+	io.WriteString(out, Sprint(value, options))
+}
+
+// Sprint formats a value. Strings are quoted, hashmaps are sorted by key and a collection which contains itself
+// prints <cycle> where it repeats.
+func Sprint(value object.Object, options Options) string {
+	p := &printer{options: options, visiting: map[object.Object]bool{}}
+
+	return p.value(value, 0, 0)
+}
+
+// value formats a value which starts at the given column and is nested depth collections deep. Lines after the first
+// are indented to the depth.
+func (p *printer) value(value object.Object, depth int, column int) string {
+	switch value := value.(type) {
+	case *object.Integer, *vm.BigInteger, *vm.Float:
+		return p.color(colorNumber, value.Inspect())
+	case *object.String:
+		return p.color(colorString, strconv.Quote(value.Value))
+	case *object.Boolean:
+		return p.color(colorBoolean, value.Inspect())
+	case *object.Null:
+		return p.color(colorNull, value.Inspect())
+	case *object.Closure, *object.CompiledFunction, *object.BuiltinFunction:
+		return p.color(colorFunction, value.Inspect())
+	case *object.Array:
+		return p.collection(value, "[", "]", len(value.Elements), depth, column, func(i int, column int) string {
+			return p.value(value.Elements[i], depth+1, column)
+		})
+	case *object.HashMap:
+		pairs := p.sortedPairs(value)
+
+		return p.collection(value, "{", "}", len(pairs), depth, column, func(i int, column int) string {
+			key := p.value(pairs[i].Key, depth+1, column) + ": "
+			return key + p.value(pairs[i].Value, depth+1, column+visibleLength(key))
+		})
+	case nil:
+		return p.color(colorNull, "nil")
+	}
+
+	return value.Inspect()
+}
+
+// collection formats the elements of an array or hashmap on a single line when they fit and one per line otherwise.
+func (p *printer) collection(value object.Object, open string, close string, length int, depth int, column int,
+	element func(i int, column int) string) string {
+	if length == 0 {
+		return open + close
+	}
+
+	if p.visiting[value] {
+		return p.color(colorOmitted, "<cycle>")
+	}
+
+	if p.options.MaxDepth > 0 && depth >= p.options.MaxDepth {
+		return open + p.color(colorOmitted, "...") + close
+	}
+
+	p.visiting[value] = true
+	defer delete(p.visiting, value)
+
+	shown := length
+	if p.options.MaxElements > 0 && shown > p.options.MaxElements {
+		shown = p.options.MaxElements
+	}
+
+	indent := strings.Repeat(p.options.Indent, depth+1)
+	elements := make([]string, 0, shown+1)
+	multiline := false
+
+	for i := 0; i < shown; i++ {
+		formatted := element(i, len(indent))
+		multiline = multiline || strings.Contains(formatted, "\n")
+		elements = append(elements, formatted)
+	}
+
+	if shown < length {
+		elements = append(elements, p.color(colorOmitted, fmt.Sprintf("... %d more", length-shown)))
+	}
+
+	line := open + strings.Join(elements, ", ") + close
+	if !multiline && (p.options.Width <= 0 || column+visibleLength(line) <= p.options.Width) {
+		return line
+	}
+
+	return open + "\n" + indent + strings.Join(elements, ",\n"+indent) + "\n" +
+		strings.Repeat(p.options.Indent, depth) + close
+}
+
+// sortedPairs orders the pairs of a hashmap, integers by value and other keys by how they print.
+func (p *printer) sortedPairs(hashMap *object.HashMap) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(hashMap.Pairs))
+	for _, pair := range hashMap.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	plain := &printer{visiting: map[object.Object]bool{}}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		left, leftOk := pairs[i].Key.(*object.Integer)
+		right, rightOk := pairs[j].Key.(*object.Integer)
+
+		if leftOk && rightOk {
+			return left.Value < right.Value
+		}
+
+		if leftOk != rightOk {
+			return leftOk
+		}
+
+		return plain.value(pairs[i].Key, 0, 0) < plain.value(pairs[j].Key, 0, 0)
+	})
+
+	return pairs
+}
+
+func (p *printer) color(code string, text string) string {
+	if !p.options.Colors {
+		return text
+	}
+
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// visibleLength is the amount of characters in text, without the color escape codes.
+func visibleLength(text string) int {
+	length := 0
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\x1b' {
+			for i < len(text) && text[i] != 'm' {
+				i++
+			}
+
+			continue
+		}
+
+		if utf8.RuneStart(text[i]) {
+			length++
+		}
+	}
+
+	return length
+}
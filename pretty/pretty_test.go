@@ -0,0 +1,86 @@
+package pretty
+
+import (
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"strings"
+	"testing"
+)
+
+func TestSprint(t *testing.T) {
+	integers := func(values ...int64) *object.Array {
+		array := &object.Array{}
+		for _, value := range values {
+			array.Elements = append(array.Elements, &object.Integer{Value: value})
+		}
+
+		return array
+	}
+
+	hashMap := func(pairs ...object.Object) *object.HashMap {
+		h := &object.HashMap{Pairs: map[object.HashKey]object.HashPair{}}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Pairs[pairs[i].(object.Hashable).Hash()] = object.HashPair{Key: pairs[i], Value: pairs[i+1]}
+		}
+
+		return h
+	}
+
+	cyclic := integers(1)
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+
+	tests := []struct {
+		name     string
+		value    object.Object
+		options  Options
+		expected string
+	}{
+		{"integer", &object.Integer{Value: -5}, Options{}, "-5"},
+		{"float", &vm.Float{Value: 2}, Options{}, "2.0"},
+		{"string", &object.String{Value: "a \"b\"\n"}, Options{}, `"a \"b\"\n"`},
+		{"empty array", &object.Array{}, Default, "[]"},
+		{"single line", &object.Array{Elements: []object.Object{integers(1, 2), integers()}}, Default, "[[1, 2], []]"},
+		{
+			"sorted keys",
+			hashMap(&object.String{Value: "b"}, vm.True, &object.Integer{Value: 10}, vm.Null,
+				&object.Integer{Value: 9}, integers(1), &object.String{Value: "a"}, vm.False),
+			Options{},
+			`{9: [1], 10: ` + vm.Null.Inspect() + `, "a": false, "b": true}`,
+		},
+		{
+			"wrapped",
+			&object.Array{Elements: []object.Object{integers(1, 2, 3), hashMap(&object.String{Value: "key"}, integers(4, 5))}},
+			Options{Indent: "  ", Width: 16},
+			"[\n  [1, 2, 3],\n  {\n    \"key\": [\n      4,\n      5\n    ]\n  }\n]",
+		},
+		{
+			"wrapped outer collection",
+			&object.Array{Elements: []object.Object{integers(1, 2, 3), hashMap(&object.String{Value: "key"}, integers(4, 5))}},
+			Options{Indent: "  ", Width: 20},
+			"[\n  [1, 2, 3],\n  {\"key\": [4, 5]}\n]",
+		},
+		{"max elements", integers(1, 2, 3, 4), Options{MaxElements: 2}, "[1, 2, ... 2 more]"},
+		{"max depth", &object.Array{Elements: []object.Object{integers(1), hashMap()}}, Options{MaxDepth: 1}, "[[...], {}]"},
+		{"cycle", cyclic, Options{}, "[1, <cycle>]"},
+		{"colors", integers(1), Options{Colors: true}, "[\x1b[33m1\x1b[0m]"},
+	}
+
+	for _, tt := range tests {
+		result := Sprint(tt.value, tt.options)
+		if result != tt.expected {
+			t.Errorf("%s: wrong result.\nwant=%q\ngot= %q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestSprint_ColorsDontCountTowardsWidth(t *testing.T) {
+	array := &object.Array{}
+	for i := 0; i < 5; i++ {
+		array.Elements = append(array.Elements, &object.Integer{Value: int64(i)})
+	}
+
+	result := Sprint(array, Options{Indent: "  ", Width: 15, Colors: true})
+	if strings.Contains(result, "\n") {
+		t.Errorf("expected the colored array to fit on a line. got=%q", result)
+	}
+}
@@ -0,0 +1,142 @@
+package repl
+
+import (
+	"fmt"
+	"github.com/looplanguage/lpvm/disasm"
+	"github.com/looplanguage/lpvm/pretty"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const help = `commands:
+  :globals          print the variables of the session
+  :disasm <expr>    print the instructions an expression compiles to, without running it
+  :reset            forget every variable and function
+  :load <file.lp>   run a source file in the session
+  :time <expr>      run an expression and print how long it took
+  :save <file>      save the inputs and variables of the session
+  :restore <file>   continue a saved session, replacing the current one
+  :quit             leave the REPL
+`
+
+var commands = []string{":help", ":globals", ":disasm", ":reset", ":load", ":time", ":save", ":restore", ":quit"}
+
+// command executes a meta-command, it returns false when the REPL should stop.
+func (sh *shell) command(input string) bool {
+	name, argument := input, ""
+	if i := strings.IndexAny(input, " \t\n"); i >= 0 {
+		name, argument = input[:i], strings.TrimSpace(input[i:])
+	}
+
+	switch name {
+	case ":help", ":h":
+		io.WriteString(sh.out, help)
+	case ":globals":
+		sh.session.printGlobals(sh.out, sh.pretty)
+	case ":disasm":
+		sh.disassemble(argument)
+	case ":reset":
+		sh.reset(newSession())
+	case ":load":
+		if argument == "" {
+			fmt.Fprintln(sh.err, "usage: :load <file.lp>")
+			break
+		}
+
+		source, err := ioutil.ReadFile(argument)
+		if err != nil {
+			fmt.Fprintln(sh.err, err)
+			break
+		}
+
+		sh.evaluate(string(source), false)
+	case ":time":
+		sh.evaluate(argument, true)
+	case ":save", ":restore":
+		if argument == "" {
+			fmt.Fprintf(sh.err, "usage: %s <file>\n", name)
+			break
+		}
+
+		if name == ":save" {
+			err := sh.session.save(argument)
+			if err != nil {
+				fmt.Fprintln(sh.err, err)
+			}
+
+			break
+		}
+
+		restored, err := restoreSession(argument)
+		if err != nil {
+			fmt.Fprintln(sh.err, err)
+			break
+		}
+
+		sh.reset(restored)
+	case ":quit", ":q":
+		return false
+	default:
+		fmt.Fprintf(sh.err, "unknown command %q, type \":help\" for a list of commands\n", name)
+	}
+
+	return true
+}
+
+// reset replaces the session. The functions of the new session reuse the ids of the old ones, so their memoized
+// results are dropped.
+func (sh *shell) reset(s *session) {
+	sh.session = s
+
+	if sh.memo != nil {
+		sh.memo.Clear()
+	}
+}
+
+func (s *session) printGlobals(out io.Writer, options pretty.Options) {
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if value := s.variables[s.names[name]]; value != nil {
+			fmt.Fprintf(out, "%s = %s\n", name, pretty.Sprint(value, options))
+		}
+	}
+
+	for i, value := range s.globals {
+		if value != nil {
+			fmt.Fprintf(out, "global %d = %s\n", i, pretty.Sprint(value, options))
+		}
+	}
+}
+
+// disassemble compiles an expression after the history and prints the new instructions, the compiler is rebuilt
+// afterwards so the expression doesn't become part of the session.
+func (sh *shell) disassemble(input string) {
+	program, ok := parse(input, sh.err)
+	if !ok {
+		return
+	}
+
+	s := sh.session
+	before := s.compiler.Bytecode()
+	ip, constant := len(before.Instructions), len(before.Constants)
+
+	for _, statement := range program.Statements {
+		err := s.compileStatement(statement)
+		if err != nil {
+			fmt.Fprintf(sh.err, "Compilation failed. \n%s\n", err)
+			s.rebuild()
+			return
+		}
+	}
+
+	disasm.FprintSince(sh.out, s.compiler.Bytecode(), ip, constant)
+	s.rebuild()
+}
@@ -0,0 +1,422 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the amount of lines kept in the history file.
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the input of the REPL one line at a time, ReadLine returns io.EOF once the input is closed and
+// errInterrupted when the user discards the input with Ctrl-C.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// scanReader reads plain lines, it is used when the input isn't a terminal.
+type scanReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scanReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+
+	if !r.scanner.Scan() {
+		return "", io.EOF
+	}
+
+	return r.scanner.Text(), nil
+}
+
+// completer returns the candidates for the word ending at pos and the offset at which the word starts.
+type completer func(line string, pos int) (int, []string)
+
+// editor is a line editor for terminals. It supports moving the cursor, the history (up, down and Ctrl-R to search
+// backwards) and tab completion. The terminal is only in raw mode while a line is read.
+type editor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	complete completer
+
+	history     []string
+	historyFile string
+
+	line []rune
+	pos  int
+}
+
+// newLineReader returns an editor when in and out are a terminal and a plain line reader otherwise.
+func newLineReader(in io.Reader, out io.Writer, complete completer) lineReader {
+	inFile, inOk := in.(*os.File)
+	outFile, outOk := out.(*os.File)
+
+	if !inOk || !outOk || !term.IsTerminal(int(inFile.Fd())) || !term.IsTerminal(int(outFile.Fd())) {
+		return &scanReader{scanner: bufio.NewScanner(in), out: out}
+	}
+
+	e := &editor{fd: int(inFile.Fd()), in: bufio.NewReader(in), out: out, complete: complete}
+	e.loadHistory(historyFile())
+
+	return e
+}
+
+// historyFile returns the file given by LPVM_HISTORY, or ~/.lpvm_history when it isn't set. An empty LPVM_HISTORY
+// disables the history file.
+func historyFile() string {
+	if file, ok := os.LookupEnv("LPVM_HISTORY"); ok {
+		return file
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".lpvm_history")
+}
+
+func (e *editor) loadHistory(file string) {
+	e.historyFile = file
+	if file == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+
+	if len(e.history) > maxHistory {
+		e.trimHistory()
+	}
+}
+
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+
+	e.history = append(e.history, line)
+
+	if len(e.history) > maxHistory {
+		e.trimHistory()
+		return
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// trimHistory keeps the last maxHistory lines and writes them over the history file.
+func (e *editor) trimHistory() {
+	e.history = e.history[len(e.history)-maxHistory:]
+
+	if e.historyFile != "" {
+		ioutil.WriteFile(e.historyFile, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+	}
+}
+
+func (e *editor) ReadLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		state, err := term.MakeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+
+		defer term.Restore(e.fd, state)
+	}
+
+	e.line, e.pos = nil, 0
+	entry := len(e.history)
+	edited := ""
+
+	e.render(prompt)
+
+	for {
+		key, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case '\r', '\n':
+			line := string(e.line)
+			io.WriteString(e.out, "\r\n")
+			e.addHistory(line)
+
+			return line, nil
+		case 3: // Ctrl-C
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+
+			e.delete()
+		case 127, 8: // Backspace
+			if e.pos > 0 {
+				e.pos--
+				e.delete()
+			}
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.line)
+		case 2: // Ctrl-B
+			e.move(-1)
+		case 6: // Ctrl-F
+			e.move(1)
+		case 11: // Ctrl-K
+			e.line = e.line[:e.pos]
+		case 21: // Ctrl-U
+			e.line = e.line[e.pos:]
+			e.pos = 0
+		case 16, 14: // Ctrl-P, Ctrl-N
+			entry, edited = e.browse(entry, edited, key == 16)
+		case 18: // Ctrl-R
+			line, done, err := e.search(prompt)
+			if err != nil || done {
+				return line, err
+			}
+		case '\t':
+			e.completeWord()
+		case 27: // Escape sequences of the arrow, home, end and delete keys
+			switch e.escape() {
+			case 'A':
+				entry, edited = e.browse(entry, edited, true)
+			case 'B':
+				entry, edited = e.browse(entry, edited, false)
+			case 'C':
+				e.move(1)
+			case 'D':
+				e.move(-1)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.line)
+			case '~':
+				e.delete()
+			}
+		default:
+			if unicode.IsPrint(key) {
+				e.insert(key)
+			}
+		}
+
+		e.render(prompt)
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final character, "delete" ("\x1b[3~") returns '~'.
+// Home and end have a few encodings, they are all returned as 'H' and 'F'.
+func (e *editor) escape() rune {
+	next, _, err := e.in.ReadRune()
+	if err != nil || (next != '[' && next != 'O') {
+		return 0
+	}
+
+	var parameter []rune
+
+	for {
+		key, _, err := e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+
+		if key >= '0' && key <= '9' || key == ';' {
+			parameter = append(parameter, key)
+			continue
+		}
+
+		if key == '~' {
+			switch string(parameter) {
+			case "1", "7":
+				return 'H'
+			case "4", "8":
+				return 'F'
+			case "3":
+				return '~'
+			}
+
+			return 0
+		}
+
+		return key
+	}
+}
+
+// browse replaces the line with an older or newer history entry, the edited line is kept to return to.
+func (e *editor) browse(entry int, edited string, older bool) (int, string) {
+	if entry == len(e.history) {
+		edited = string(e.line)
+	}
+
+	if older && entry > 0 {
+		entry--
+	} else if !older && entry < len(e.history) {
+		entry++
+	} else {
+		return entry, edited
+	}
+
+	if entry == len(e.history) {
+		e.line = []rune(edited)
+	} else {
+		e.line = []rune(e.history[entry])
+	}
+
+	e.pos = len(e.line)
+
+	return entry, edited
+}
+
+// search looks backwards through the history for lines containing what is typed. Enter runs the match, Ctrl-G
+// restores the line and any other key keeps the match for editing.
+func (e *editor) search(prompt string) (string, bool, error) {
+	var query []rune
+	original, originalPos := e.line, e.pos
+	match := len(e.history)
+
+	find := func(from int) {
+		if from >= len(e.history) {
+			from = len(e.history) - 1
+		}
+
+		for i := from; i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				match = i
+				e.line = []rune(e.history[i])
+				e.pos = len(e.line)
+				return
+			}
+		}
+	}
+
+	for {
+		fmt.Fprintf(e.out, "\r\x1b[K(reverse-i-search)`%s': %s", string(query), string(e.line))
+
+		key, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", false, err
+		}
+
+		switch {
+		case key == 18: // Ctrl-R, the next older match
+			find(match - 1)
+		case key == 127 || key == 8:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case key == '\r' || key == '\n':
+			line := string(e.line)
+			fmt.Fprintf(e.out, "\r\x1b[K%s%s\r\n", prompt, line)
+			e.addHistory(line)
+
+			return line, true, nil
+		case key == 7: // Ctrl-G
+			e.line, e.pos = original, originalPos
+			return "", false, nil
+		case key == 27:
+			e.escape()
+			return "", false, nil
+		case unicode.IsPrint(key):
+			query = append(query, key)
+			find(match)
+		default:
+			return "", false, nil
+		}
+	}
+}
+
+// completeWord completes the word before the cursor, when there are several candidates the common prefix is added
+// and the candidates are listed.
+func (e *editor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+
+	line := string(e.line)
+	end := len(string(e.line[:e.pos]))
+
+	start, candidates := e.complete(line, end)
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := line[start:end]
+	prefix := candidates[0]
+
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	for _, r := range strings.TrimPrefix(prefix, word) {
+		e.insert(r)
+	}
+
+	if len(candidates) > 1 && prefix == word {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+func (e *editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = r
+	e.pos++
+}
+
+// delete removes the character under the cursor.
+func (e *editor) delete() {
+	if e.pos < len(e.line) {
+		e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+	}
+}
+
+func (e *editor) move(offset int) {
+	if e.pos+offset >= 0 && e.pos+offset <= len(e.line) {
+		e.pos += offset
+	}
+}
+
+// render redraws the line and puts the cursor back in its place.
+func (e *editor) render(prompt string) {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(e.line))
+
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
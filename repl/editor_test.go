@@ -0,0 +1,123 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditor_ReadLine(t *testing.T) {
+	sh := &shell{session: newSession(), out: ioutil.Discard, err: ioutil.Discard}
+	sh.evaluate("var length = 1", false)
+
+	tests := []struct {
+		name     string
+		keys     string
+		expected string
+		err      error
+	}{
+		{"typing", "1 + 2\r", "1 + 2", nil},
+		{"cursor movement", "ac\x1b[Db\x1b[C!\r", "abc!", nil},
+		{"home and end", "bc\x01a\x05d\r", "abcd", nil},
+		{"backspace and delete", "abxc\x7f\x7fc\x01\x1b[3~\r", "bc", nil},
+		{"kill", "abc\x02\x0b\r", "ab", nil},
+		{"history", "\x1b[A\x1b[A\r", "three", nil},
+		{"history back to the edited line", "new\x1b[A\x1b[B\r", "new", nil},
+		{"reverse search", "\x12on\r", "one", nil},
+		{"reverse search older match", "\x12o\x12\r", "one", nil},
+		{"reverse search keeps the match", "\x12tw\x06!\r", "two!", nil},
+		{"reverse search cancelled", "x\x12tw\x07\r", "x", nil},
+		{"complete builtin", "le\t\r", "len", nil},
+		{"complete variable", "1 + leng\t\r", "1 + length", nil},
+		{"complete common prefix", "l\t\r", "len", nil},
+		{"complete command", ":gl\t\r", ":globals", nil},
+		{"interrupt", "abc\x03", "", errInterrupted},
+		{"end of input", "\x04", "", io.EOF},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+
+		e := &editor{fd: -1, in: bufio.NewReader(strings.NewReader(tt.keys)), out: &out, complete: sh.session.complete}
+		e.history = []string{"one", "three", "two"}
+
+		line, err := e.ReadLine("> ")
+		if line != tt.expected || err != tt.err {
+			t.Errorf("%s: expected %q, %v. got=%q, %v", tt.name, tt.expected, tt.err, line, err)
+		}
+	}
+}
+
+func TestEditor_History(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "history")
+
+	e := &editor{fd: -1, in: bufio.NewReader(strings.NewReader("var a = 1\r\r1\r1\r")), out: ioutil.Discard}
+	e.loadHistory(file)
+
+	for i := 0; i < 4; i++ {
+		e.ReadLine("> ")
+	}
+
+	e = &editor{fd: -1, in: bufio.NewReader(strings.NewReader("\x1b[A\x1b[A\r")), out: ioutil.Discard}
+	e.loadHistory(file)
+
+	if len(e.history) != 2 {
+		t.Fatalf("expected 2 history entries. got=%q", e.history)
+	}
+
+	line, _ := e.ReadLine("> ")
+	if line != "var a = 1" {
+		t.Errorf("expected the history of the previous editor. got=%q", line)
+	}
+}
+
+func TestEditor_HistoryLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "history")
+
+	e := &editor{fd: -1, out: ioutil.Discard}
+	e.loadHistory(file)
+
+	for i := 0; i < maxHistory+5; i++ {
+		e.addHistory(fmt.Sprintf("%d", i))
+	}
+
+	if len(e.history) != maxHistory || e.history[0] != "5" {
+		t.Errorf("expected the oldest lines to be dropped. got=%d lines starting with %q", len(e.history), e.history[0])
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != maxHistory || lines[0] != "5" || lines[len(lines)-1] != fmt.Sprintf("%d", maxHistory+4) {
+		t.Errorf("expected the history file to be trimmed. got=%d lines", len(lines))
+	}
+}
+
+func TestNewLineReader(t *testing.T) {
+	reader := newLineReader(strings.NewReader("1\n"), ioutil.Discard, nil)
+
+	if _, ok := reader.(*scanReader); !ok {
+		t.Errorf("expected a plain line reader for input which isn't a terminal. got=%T", reader)
+	}
+}
@@ -1,70 +1,236 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
-	"github.com/looplanguage/compiler/compiler"
 	"github.com/looplanguage/loop/lexer"
-	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/loop/models/ast"
 	"github.com/looplanguage/loop/parser"
+	"github.com/looplanguage/lpvm/pretty"
 	"github.com/looplanguage/lpvm/vm"
 	"io"
+	"os"
+	"strings"
+	"time"
 )
 
-// TODO: For testing, remove in eventual build & replace with it's own executable.
+// Options configures a REPL, the zero value writes errors to the same writer as the results and doesn't keep the
+// session.
+type Options struct {
+	// Err receives parser, compiler and runtime errors, nil uses the output writer.
+	Err io.Writer
+	// Pretty is how results are printed, nil uses pretty.Default.
+	Pretty *pretty.Options
+	// MemoCache memoizes the pure functions of the session, nil disables memoization.
+	MemoCache *vm.MemoCache
+	// Session is a file to continue the session from when it exists and to save the session to when the REPL stops.
+	Session string
+}
 
+// shell reads the inputs of a REPL and evaluates them in its session. Results go to out, errors go to err.
+type shell struct {
+	session *session
+	out     io.Writer
+	err     io.Writer
+	pretty  pretty.Options
+	memo    *vm.MemoCache
+}
+
+// Start runs a REPL with the default options until the input ends or the user quits.
+//
+// TODO: For testing, remove in eventual build & replace with it's own executable.
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	StartWithOptions(in, out, Options{})
+}
 
-	i := 0
+// StartSession is like Start, but continues the session saved in file when it exists and saves the session to file
+// when the REPL stops.
+func StartSession(in io.Reader, out io.Writer, file string) error {
+	return StartWithOptions(in, out, Options{Session: file})
+}
+
+// StartWithOptions runs a REPL until the input ends or the user quits, only saving the session can fail.
+func StartWithOptions(in io.Reader, out io.Writer, options Options) error {
+	sh := &shell{session: newSession(), out: out, err: options.Err, pretty: pretty.Default, memo: options.MemoCache}
+	if sh.err == nil {
+		sh.err = out
+	}
+
+	if options.Pretty != nil {
+		sh.pretty = *options.Pretty
+	}
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.CreateSymbolTable()
+	if options.Session != "" {
+		if _, err := os.Stat(options.Session); err == nil {
+			sh.session, err = restoreSession(options.Session)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sh.loop(in)
+
+	if options.Session == "" {
+		return nil
+	}
+
+	return sh.session.save(options.Session)
+}
+
+func (sh *shell) loop(in io.Reader) {
+	reader := newLineReader(in, sh.out, func(line string, pos int) (int, []string) {
+		return sh.session.complete(line, pos)
+	})
+
+	i := 0
 
 	for {
 		i++
-		io.WriteString(out, fmt.Sprintf("%d", i))
-		io.WriteString(out, " > ")
-		scanned := scanner.Scan()
 
-		if !scanned {
+		input, ok := readInput(reader, fmt.Sprintf("%d > ", i))
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.Create(line)
-		p := parser.Create(l)
+		if !sh.handle(input) {
+			return
+		}
+	}
+}
 
-		program := p.Parse()
-		if len(p.Errors) != 0 {
-			for _, e := range p.Errors {
-				fmt.Println(e)
-			}
-			continue
+// handle evaluates an input or executes a meta-command, it returns false when the REPL should stop. A panic only
+// discards the input, the compiler is rebuilt in case it happened halfway through compiling.
+func (sh *shell) handle(input string) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(sh.err, "recovered from panic: %v\n", r)
+			sh.session.rebuild()
+			ok = true
 		}
+	}()
+
+	trimmed := strings.TrimSpace(input)
+
+	if trimmed == "" {
+		return true
+	}
 
-		comp := compiler.CreateWithState(symbolTable, constants)
-		err := comp.Compile(program, "", "", "")
+	if strings.HasPrefix(trimmed, ":") {
+		return sh.command(trimmed)
+	}
+
+	sh.evaluate(input, false)
+
+	return true
+}
+
+// readInput reads lines until every brace, bracket and parenthesis of the input is closed. Interrupting discards the
+// input.
+func readInput(reader lineReader, prompt string) (string, bool) {
+	var lines []string
+
+	for {
+		line, err := reader.ReadLine(prompt)
+		if err == errInterrupted {
+			return "", true
+		}
 
 		if err != nil {
-			fmt.Fprintf(out, "Compilation failed. \n%s\n", err)
-			continue
+			return "", false
 		}
 
-		code := comp.Bytecode()
-		constants = code.Constants
+		lines = append(lines, line)
 
-		machine := vm.CreateWithStore(code, globals)
+		input := strings.Join(lines, "\n")
+		if complete(input) {
+			return input, true
+		}
 
-		err = machine.Run(nil)
-		if err != nil {
-			fmt.Fprintf(out, "vm failed running bytecode with: \n%s\n", err)
-			continue
+		prompt = "... "
+	}
+}
+
+// complete reports whether the input has no unclosed braces, brackets or parentheses outside of strings. Closing
+// too many is left to the parser to report.
+func complete(input string) bool {
+	depth := 0
+	inString := false
+
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+// parse parses an input and writes the parser errors to errOut.
+func parse(input string, errOut io.Writer) (*ast.Program, bool) {
+	l := lexer.Create(input)
+	p := parser.Create(l)
+
+	program := p.Parse()
+	if len(p.Errors) != 0 {
+		for _, e := range p.Errors {
+			fmt.Fprintln(errOut, e)
 		}
 
-		stackTop := machine.LastPoppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
+		return nil, false
+	}
+
+	return program, true
+}
+
+// evaluate compiles and runs an input, timed also prints how long running it took. Only an input ending with an
+// expression has a result, declarations and assignments print nothing.
+func (sh *shell) evaluate(input string, timed bool) {
+	program, ok := parse(input, sh.err)
+	if !ok {
+		return
+	}
+
+	bytecode, err := sh.session.compile(input, program)
+	if err != nil {
+		fmt.Fprintf(sh.err, "Compilation failed. \n%s\n", err)
+		return
 	}
+
+	start := time.Now()
+	result, err := sh.session.run(bytecode, sh.memo)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		fmt.Fprintf(sh.err, "vm failed running bytecode with: \n%s\n", err)
+		return
+	}
+
+	if result != nil && endsWithExpression(program) {
+		pretty.Fprint(sh.out, result, sh.pretty)
+		io.WriteString(sh.out, "\n")
+	}
+
+	if timed {
+		fmt.Fprintf(sh.out, "took %s\n", elapsed)
+	}
+}
+
+// endsWithExpression reports whether the last statement leaves a value behind, the VM pops the value of an
+// expression statement but nothing for other statements.
+func endsWithExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+
+	return ok
 }
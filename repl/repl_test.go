@@ -0,0 +1,301 @@
+package repl
+
+import (
+	"bytes"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/pretty"
+	"github.com/looplanguage/lpvm/vm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "double.lp")
+	err = ioutil.WriteFile(file, []byte("var double = fun(x) {\n  return x * 2\n}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"var a = 20",
+		"var f = fun(x) {",
+		"  return x + a",
+		"}",
+		"f(1) + 1",
+		"undefined + 1",
+		"a * 2",
+		":load " + file,
+		"double(a)",
+		":globals",
+		":disasm f(2)",
+		":time f(3)",
+		":reset",
+		"a",
+		":unknown",
+		":quit",
+		"1",
+	}, "\n")
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	expected := []string{
+		"2 > ... ... ",
+		"22\n",
+		"Compilation failed. \nundefined variable undefined\n",
+		"40\n",
+		"> a = 20\n",
+		"> main:\n",
+		"OpCall 1\t; args=1\n",
+		"23\ntook ",
+		"Compilation failed. \nundefined variable a\n",
+		"unknown command \":unknown\"",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out.String(), e) {
+			t.Errorf("output does not contain %q.\n%s", e, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "15 >") {
+		t.Errorf("expected the REPL to stop at :quit.\n%s", out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", true},
+		{"fun(x) {", false},
+		{"fun(x) {\n return x \n}", true},
+		{"f(1,\n", false},
+		{"[1, [2]", false},
+		{`"{"`, true},
+		{`"\"{"`, true},
+		{"}", true},
+	}
+
+	for _, tt := range tests {
+		if complete(tt.input) != tt.expected {
+			t.Errorf("wrong result for %q. want=%t", tt.input, tt.expected)
+		}
+	}
+}
+
+func TestSession_SaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "session.lps")
+
+	input := strings.Join([]string{
+		"var a = [1, 2]",
+		"var b = a",
+		`var h = {"n": 9223372036854775807, "len": len}`,
+		"var add = fun(x) { return x + h[\"n\"] }",
+	}, "\n")
+
+	s := newSession()
+	(&shell{session: s, out: ioutil.Discard, err: ioutil.Discard}).loop(strings.NewReader(input))
+
+	// Printing an array which contains itself never ends, so the cycle isn't created by an input
+	array := s.variables[s.names["a"]].(*object.Array)
+	array.Elements[0] = array
+
+	err = s.save(file)
+	if err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+
+	// A save which fails leaves what is at the path and no temporary files behind
+	err = os.Mkdir(filepath.Join(dir, "directory"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "directory", "file"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.save(filepath.Join(dir, "directory")); err == nil {
+		t.Errorf("expected an error when saving over a directory")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("expected only the session and the directory to remain. got=%d entries", len(entries))
+	}
+
+	s, err = restoreSession(file)
+	if err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	a, ok := s.variables[s.names["a"]].(*object.Array)
+	if !ok || len(a.Elements) != 2 || a.Elements[0] != a {
+		t.Errorf("expected a to contain itself. got=%v", s.variables[s.names["a"]])
+	}
+
+	if s.variables[s.names["b"]] != a {
+		t.Errorf("expected a and b to share their array")
+	}
+
+	var out bytes.Buffer
+	err = StartSession(strings.NewReader("add(0 - 7)\nb[1]\nh[\"len\"](\"abc\")\nvar c = 3"), &out, file)
+	if err != nil {
+		t.Fatalf("session error: %s", err)
+	}
+
+	for _, expected := range []string{"1 > 9223372036854775800\n", "2 > 2\n", "3 > 3\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q.\n%s", expected, out.String())
+		}
+	}
+
+	s, err = restoreSession(file)
+	if err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	if c, ok := s.variables[s.names["c"]].(*object.Integer); !ok || c.Value != 3 {
+		t.Errorf("expected the session to be saved when the REPL stops. got=%v", s.variables[s.names["c"]])
+	}
+
+	err = ioutil.WriteFile(file, []byte("not a session"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restoreSession(file); err == nil {
+		t.Errorf("expected an error for an invalid session file")
+	}
+}
+
+func TestStartWithOptions(t *testing.T) {
+	input := strings.Join([]string{
+		"var a = 1",
+		"a = 2",
+		"a",
+		"a +",
+		"undefined",
+		"1 / 0",
+		":unknown",
+		"a + 1",
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	err := StartWithOptions(strings.NewReader(input), &out, Options{Err: &errOut})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedOut := "1 > 2 > 3 > 2\n4 > 5 > 6 > 7 > 8 > 3\n9 > "
+	if out.String() != expectedOut {
+		t.Errorf("wrong output. want=%q, got=%q", expectedOut, out.String())
+	}
+
+	for _, expected := range []string{"undefined variable undefined", "vm failed running bytecode", "unknown command"} {
+		if !strings.Contains(errOut.String(), expected) {
+			t.Errorf("errors do not contain %q.\n%s", expected, errOut.String())
+		}
+	}
+
+	if strings.Count(errOut.String(), "Compilation failed.") != 1 {
+		t.Errorf("expected one compilation error.\n%s", errOut.String())
+	}
+}
+
+func TestShell_RecoversFromPanic(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sh := &shell{session: newSession(), out: &out, err: &errOut}
+
+	sh.evaluate("var a = 20", false)
+
+	// A session without a compiler panics while compiling, like a compiler bug would
+	sh.session.compiler = nil
+
+	if !sh.handle("a + 1") {
+		t.Fatalf("expected the REPL to continue after a panic")
+	}
+
+	if !strings.Contains(errOut.String(), "recovered from panic") {
+		t.Errorf("expected the panic to be reported.\n%s", errOut.String())
+	}
+
+	sh.handle("a + 2")
+
+	if out.String() != "22\n" {
+		t.Errorf("wrong output after the panic. want=%q, got=%q", "22\n", out.String())
+	}
+}
+
+func TestStartWithOptions_Pretty(t *testing.T) {
+	var out bytes.Buffer
+	options := Options{Pretty: &pretty.Options{Indent: "  ", Width: 12}}
+
+	err := StartWithOptions(strings.NewReader("var a = [1, [2, 3], \"b\"]\na\n:globals"), &out, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "2 > [\n  1,\n  [2, 3],\n  \"b\"\n]\n3 > a = [\n  1,\n  [2, 3],\n  \"b\"\n]\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("output does not contain %q.\n%s", expected, out.String())
+	}
+}
+
+func TestStartWithOptions_MemoCache(t *testing.T) {
+	cache := vm.NewMemoCache()
+	input := "var double = fun(x) { return x * 2 }\ndouble(2)\ndouble(2)\n"
+
+	err := StartWithOptions(strings.NewReader(input), ioutil.Discard, Options{MemoCache: cache})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected the second call to be memoized. got=%+v", stats)
+	}
+
+	err = StartWithOptions(strings.NewReader(input+":reset\n"), ioutil.Discard, Options{MemoCache: cache})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cache.Len() != 0 {
+		t.Errorf("expected :reset to clear the cache. got=%d entries", cache.Len())
+	}
+
+	// Setting g again makes f impure, so its cached result isn't used anymore
+	var out bytes.Buffer
+	input = "var g = fun() { return 1 }\nvar f = fun() { return g() }\nf()\ng = fun() { return 2 }\nf()\n"
+
+	err = StartWithOptions(strings.NewReader(input), &out, Options{MemoCache: vm.NewMemoCache()})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "5 > 2\n") {
+		t.Errorf("expected f to call the new g.\n%s", out.String())
+	}
+}
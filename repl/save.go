@@ -0,0 +1,322 @@
+package repl
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// sessionVersion changes whenever the format of a saved session does.
+const sessionVersion = 1
+
+// savedSession is what a session file contains. The symbol table and constant pool can't be read from a compiler,
+// so the inputs are saved instead and compiled again when the session is restored. Values are stored once in Values
+// and referenced by index, which keeps values shared by several variables shared and allows cycles.
+type savedSession struct {
+	Version   int
+	Inputs    []string
+	Values    []savedValue
+	Globals   map[int]int
+	Variables map[int]int
+}
+
+// savedValue is a single value, the fields which are used depend on the kind. Elements holds the indexes of the
+// elements of an array, the keys and values of a hashmap or the free variables of a closure.
+type savedValue struct {
+	Kind     string
+	Integer  int64
+	Float    float64
+	String   string
+	Boolean  bool
+	Elements []int
+}
+
+// save writes the session to a file, see savedSession.
+func (s *session) save(path string) error {
+	saved := savedSession{
+		Version:   sessionVersion,
+		Inputs:    s.inputs,
+		Globals:   map[int]int{},
+		Variables: map[int]int{},
+	}
+
+	indexes := map[object.Object]int{}
+	functions := vm.FunctionIds(s.compiler.Bytecode().Constants)
+
+	for i, value := range s.globals {
+		if value != nil {
+			index, err := saved.add(value, indexes, functions)
+			if err != nil {
+				return err
+			}
+
+			saved.Globals[i] = index
+		}
+	}
+
+	for i, value := range s.variables {
+		if value != nil {
+			index, err := saved.add(value, indexes, functions)
+			if err != nil {
+				return err
+			}
+
+			saved.Variables[i] = index
+		}
+	}
+
+	// The session is written next to the file and renamed over it, so a failed save keeps the previous session
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = gob.NewEncoder(file).Encode(&saved)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// add stores a value and everything it references, the index of a value which was already stored is reused.
+// Functions are looked up in the ids of the session's constants.
+func (saved *savedSession) add(value object.Object, indexes map[object.Object]int,
+	functions map[*object.CompiledFunction]int) (int, error) {
+	if index, ok := indexes[value]; ok {
+		return index, nil
+	}
+
+	index := len(saved.Values)
+	indexes[value] = index
+	saved.Values = append(saved.Values, savedValue{})
+
+	var stored savedValue
+	var elements []object.Object
+
+	switch value := value.(type) {
+	case *object.Integer:
+		stored.Kind = "integer"
+		stored.Integer = value.Value
+	case *vm.BigInteger:
+		stored.Kind = "biginteger"
+		stored.String = value.Value.String()
+	case *vm.Float:
+		stored.Kind = "float"
+		stored.Float = value.Value
+	case *object.String:
+		stored.Kind = "string"
+		stored.String = value.Value
+	case *object.Boolean:
+		stored.Kind = "boolean"
+		stored.Boolean = value.Value
+	case *object.Null:
+		stored.Kind = "null"
+	case *object.Array:
+		stored.Kind = "array"
+		elements = value.Elements
+	case *object.HashMap:
+		stored.Kind = "hashmap"
+		for _, pair := range value.Pairs {
+			elements = append(elements, pair.Key, pair.Value)
+		}
+	case *object.Closure:
+		// Function ids are the constant index plus one, the constants are the same after compiling the inputs again
+		id, ok := functions[value.Fn]
+		if !ok {
+			return 0, fmt.Errorf("unable to save function which isn't a constant of the session")
+		}
+
+		stored.Kind = "closure"
+		stored.Integer = int64(id - 1)
+		elements = value.Free
+	case *object.BuiltinFunction:
+		name, ok := vm.BuiltinName(value)
+		if !ok {
+			return 0, fmt.Errorf("unable to save unknown builtin function")
+		}
+
+		stored.Kind = "builtin"
+		stored.String = name
+	default:
+		return 0, fmt.Errorf("unable to save value of type %q", value.Type())
+	}
+
+	for _, element := range elements {
+		elementIndex, err := saved.add(element, indexes, functions)
+		if err != nil {
+			return 0, err
+		}
+
+		stored.Elements = append(stored.Elements, elementIndex)
+	}
+
+	saved.Values[index] = stored
+
+	return index, nil
+}
+
+// restoreSession reads a session file, the inputs are compiled again but not run.
+func restoreSession(path string) (*session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var saved savedSession
+
+	err = gob.NewDecoder(file).Decode(&saved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %w", path, err)
+	}
+
+	if saved.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session file version %d", saved.Version)
+	}
+
+	s := newSession()
+
+	for _, input := range saved.Inputs {
+		program, ok := parse(input, ioutil.Discard)
+		if !ok {
+			return nil, errors.New("unable to parse the inputs of the session")
+		}
+
+		_, err = s.compile(input, program)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bytecode := s.compiler.Bytecode()
+	s.ran = len(bytecode.Instructions)
+
+	values, err := saved.restoreValues(bytecode.Constants)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range saved.Globals {
+		if i < 0 || i >= len(s.globals) || index < 0 || index >= len(values) {
+			return nil, fmt.Errorf("global %d out of range", i)
+		}
+
+		s.globals[i] = values[index]
+	}
+
+	for i, index := range saved.Variables {
+		if i < 0 || i >= len(s.variables) || index < 0 || index >= len(values) {
+			return nil, fmt.Errorf("variable %d out of range", i)
+		}
+
+		s.variables[i] = values[index]
+	}
+
+	return s, nil
+}
+
+// restoreValues creates every value before filling in the references between them, so cycles can be restored.
+func (saved *savedSession) restoreValues(constants []object.Object) ([]object.Object, error) {
+	values := make([]object.Object, len(saved.Values))
+
+	for i, value := range saved.Values {
+		switch value.Kind {
+		case "integer":
+			values[i] = &object.Integer{Value: value.Integer}
+		case "biginteger":
+			integer, ok := new(big.Int).SetString(value.String, 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid big integer %q", value.String)
+			}
+
+			values[i] = &vm.BigInteger{Value: integer}
+		case "float":
+			values[i] = &vm.Float{Value: value.Float}
+		case "string":
+			values[i] = &object.String{Value: value.String}
+		case "boolean":
+			if value.Boolean {
+				values[i] = vm.True
+			} else {
+				values[i] = vm.False
+			}
+		case "null":
+			values[i] = vm.Null
+		case "array":
+			values[i] = &object.Array{}
+		case "hashmap":
+			values[i] = &object.HashMap{Pairs: map[object.HashKey]object.HashPair{}}
+		case "closure":
+			constant := int(value.Integer)
+			if constant < 0 || constant >= len(constants) {
+				return nil, fmt.Errorf("function constant %d out of range", constant)
+			}
+
+			fn, ok := constants[constant].(*object.CompiledFunction)
+			if !ok {
+				return nil, fmt.Errorf("constant %d is not a function", constant)
+			}
+
+			values[i] = &object.Closure{Fn: fn}
+		case "builtin":
+			fn, ok := vm.LookupBuiltin(value.String)
+			if !ok {
+				return nil, fmt.Errorf("unknown builtin function %q", value.String)
+			}
+
+			values[i] = fn
+		default:
+			return nil, fmt.Errorf("unable to restore value of kind %q", value.Kind)
+		}
+	}
+
+	for i, value := range saved.Values {
+		var elements []object.Object
+
+		for _, index := range value.Elements {
+			if index < 0 || index >= len(values) {
+				return nil, fmt.Errorf("value %d out of range", index)
+			}
+
+			elements = append(elements, values[index])
+		}
+
+		switch restored := values[i].(type) {
+		case *object.Array:
+			restored.Elements = elements
+		case *object.Closure:
+			restored.Free = elements
+		case *object.HashMap:
+			for j := 0; j+1 < len(elements); j += 2 {
+				key, ok := elements[j].(object.Hashable)
+				if !ok {
+					return nil, fmt.Errorf("unable to use %q as hashmap key", elements[j].Type())
+				}
+
+				restored.Pairs[key.Hash()] = object.HashPair{Key: elements[j], Value: elements[j+1]}
+			}
+		}
+	}
+
+	return values, nil
+}
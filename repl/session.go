@@ -0,0 +1,165 @@
+package repl
+
+import (
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/ast"
+	"github.com/looplanguage/loop/models/object"
+	"github.com/looplanguage/lpvm/vm"
+	"sort"
+	"strings"
+)
+
+// session holds the state shared by the inputs of a REPL. The compiler can't continue from the variables of an
+// earlier compiler, so every input is compiled by the same compiler and the VM only runs the instructions which were
+// added since the previous input.
+type session struct {
+	compiler *compiler.Compiler
+	symbols  *compiler.SymbolTable
+	history  []ast.Statement
+	inputs   []string
+	names    map[string]int
+	ran      int
+	purity   *vm.PurityAnalyzer
+
+	globals   []object.Object
+	variables []object.Object
+}
+
+func newSession() *session {
+	s := &session{
+		names:     map[string]int{},
+		globals:   make([]object.Object, vm.GlobalsSize),
+		variables: make([]object.Object, vm.GlobalsSize),
+		purity:    vm.NewPurityAnalyzer(),
+	}
+
+	s.compiler, s.symbols = newCompiler()
+
+	return s
+}
+
+// newCompiler creates a compiler and keeps its symbol table, which compiler.Create doesn't expose.
+func newCompiler() (*compiler.Compiler, *compiler.SymbolTable) {
+	symbols := compiler.CreateSymbolTable()
+
+	for i, builtin := range object.Builtins {
+		symbols.DefineBuiltin(i, builtin.Name)
+	}
+
+	return compiler.CreateWithState(symbols, []object.Object{}), symbols
+}
+
+// compile adds the program parsed from an input to the session and returns the bytecode of every input so far. A
+// failed compilation leaves the compiler in an unknown state, so it is rebuilt from the inputs which did compile.
+func (s *session) compile(input string, program *ast.Program) (*compiler.Bytecode, error) {
+	for _, statement := range program.Statements {
+		err := s.compileStatement(statement)
+		if err != nil {
+			s.rebuild()
+			return nil, err
+		}
+	}
+
+	s.history = append(s.history, program.Statements...)
+	s.inputs = append(s.inputs, input)
+
+	return s.compiler.Bytecode(), nil
+}
+
+func (s *session) compileStatement(statement ast.Statement) error {
+	err := s.compiler.Compile(statement, "", "", "")
+	if err != nil {
+		return err
+	}
+
+	// A declaration ends with the OpSetVar storing its value, which tells the index of the variable
+	if declaration, ok := statement.(*ast.VariableDeclaration); ok {
+		ins := s.compiler.Bytecode().Instructions
+
+		if len(ins) >= 3 && code.OpCode(ins[len(ins)-3]) == code.OpSetVar {
+			s.names[declaration.Identifier.Value] = int(code.ReadUint16(ins[len(ins)-2:]))
+		}
+	}
+
+	return nil
+}
+
+// rebuild recompiles the history with a new compiler, which emits the same instructions as before.
+func (s *session) rebuild() {
+	s.compiler, s.symbols = newCompiler()
+	s.names = map[string]int{}
+
+	// The new compiler creates new functions, which the analysis of the old ones doesn't know
+	s.purity = vm.NewPurityAnalyzer()
+
+	for _, statement := range s.history {
+		s.compileStatement(statement)
+	}
+}
+
+// run executes the instructions which were added since the previous run, the result is nil when nothing was popped.
+// Function ids don't change between inputs, so memo can be used for the whole session. The purity of the functions is
+// analyzed incrementally, so every input only analyzes the code it added.
+func (s *session) run(bytecode *compiler.Bytecode, memo *vm.MemoCache) (object.Object, error) {
+	machine := vm.CreateWithState(bytecode, s.globals, s.variables)
+	machine.SetStart(s.ran)
+
+	if memo != nil {
+		machine.SetPurity(s.purity.Update(bytecode))
+		machine.SetMemoCache(memo)
+	}
+
+	s.ran = len(bytecode.Instructions)
+
+	err := machine.Run(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return machine.LastPoppedStackElem(), nil
+}
+
+// complete returns the variables, symbols and builtin functions starting with the word before pos. A word starting
+// with a colon at the beginning of the line is completed as a meta-command.
+func (s *session) complete(line string, pos int) (int, []string) {
+	start := pos
+	for start > 0 && isIdentifier(line[start-1]) {
+		start--
+	}
+
+	var names []string
+
+	if start == 1 && line[0] == ':' {
+		start = 0
+		names = commands
+	} else {
+		for name := range s.symbols.GetAllVariables(map[string]compiler.Symbol{}) {
+			names = append(names, name)
+		}
+
+		for name := range s.names {
+			names = append(names, name)
+		}
+	}
+
+	word := line[start:pos]
+	seen := map[string]bool{}
+
+	var candidates []string
+
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+
+	sort.Strings(candidates)
+
+	return start, candidates
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,25 @@
+package vm
+
+import "github.com/looplanguage/loop/models/object"
+
+// BuiltinName returns the name of a builtin function of object.Builtins.
+func BuiltinName(fn *object.BuiltinFunction) (string, bool) {
+	for _, definition := range object.Builtins {
+		if definition.Builtin == fn {
+			return definition.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// LookupBuiltin returns the builtin function of object.Builtins with the given name.
+func LookupBuiltin(name string) (*object.BuiltinFunction, bool) {
+	for _, definition := range object.Builtins {
+		if definition.Name == name {
+			return definition.Builtin, true
+		}
+	}
+
+	return nil, false
+}
@@ -1,53 +1,179 @@
 package vm
 
 import (
-	"fmt"
 	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/loop/models/object"
 )
 
+// compareOperator executes OpEquals, OpNotEquals and OpGreaterThan. The compiler has no other comparison opcodes,
+// "a < b" is compiled as "b > a".
 func (vm *VM) compareOperator(op code.OpCode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER || right.Type() == object.INTEGER {
-		return vm.compareInteger(op, left, right)
-	}
-
 	switch op {
 	case code.OpEquals:
-		return vm.push(getBoolean(left == right))
+		return vm.push(getBoolean(valuesEqual(left, right, nil)))
 	case code.OpNotEquals:
-		return vm.push(getBoolean(left != right))
-	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return vm.push(getBoolean(!valuesEqual(left, right, nil)))
 	}
+
+	greater, err := compareOrder(op, left, right)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(getBoolean(greater > 0))
 }
 
-func (vm *VM) compareInteger(op code.OpCode, left object.Object, right object.Object) error {
-	leftObj, ok := left.(*object.Integer)
-	if !ok {
-		return fmt.Errorf("left comparison is not of type integer. got=%q", left.Type())
+// compareOrder returns a negative number, zero or a positive number when left is smaller than, equal to or greater
+// than right. Only numbers and strings are ordered, strings are compared byte by byte. A float is compared with an
+// integer by promoting the integer, NaN is neither smaller nor greater than anything.
+func compareOrder(op code.OpCode, left object.Object, right object.Object) (int, error) {
+	_, leftFloat := left.(*Float)
+	_, rightFloat := right.(*Float)
+
+	if leftFloat || rightFloat {
+		if left, ok := toFloat(left); ok {
+			if right, ok := toFloat(right); ok {
+				switch {
+				case left < right:
+					return -1, nil
+				case left > right:
+					return 1, nil
+				}
+
+				return 0, nil
+			}
+		}
 	}
 
-	rightObj, ok := right.(*object.Integer)
-	if !ok {
-		return fmt.Errorf("right comparison is not of type integer. got=%q", left.Type())
+	if leftBig, ok := left.(*BigInteger); ok {
+		if rightBig, ok := toBigInt(right); ok {
+			return leftBig.Value.Cmp(rightBig), nil
+		}
 	}
 
-	leftValue := leftObj.Value
-	rightValue := rightObj.Value
+	if rightBig, ok := right.(*BigInteger); ok {
+		if leftBig, ok := toBigInt(left); ok {
+			return leftBig.Cmp(rightBig.Value), nil
+		}
+	}
 
-	switch op {
-	case code.OpEquals:
-		return vm.push(getBoolean(leftValue == rightValue))
-	case code.OpNotEquals:
-		return vm.push(getBoolean(leftValue != rightValue))
-	case code.OpGreaterThan:
-		return vm.push(getBoolean(leftValue > rightValue))
-	default:
-		return fmt.Errorf("unknown operator: %d", op)
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			switch {
+			case left.Value < right.Value:
+				return -1, nil
+			case left.Value > right.Value:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			switch {
+			case left.Value < right.Value:
+				return -1, nil
+			case left.Value > right.Value:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	}
+
+	return 0, &TypeError{OpCode: op, Types: []string{left.Type(), right.Type()}}
+}
+
+// valuesEqual compares by value, values of different types are never equal except for numbers. Arrays and hashmaps
+// are equal when their elements are, functions and closures only equal themselves. Seen holds the collections which
+// are already being compared, so an array containing itself doesn't recurse forever.
+func valuesEqual(left object.Object, right object.Object, seen map[[2]object.Object]bool) bool {
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*Float); ok {
+			return floatEqualsInteger(right.Value, left)
+		}
+
+		right, ok := right.(*object.Integer)
+		return ok && left.Value == right.Value
+	case *BigInteger:
+		if right, ok := right.(*Float); ok {
+			return valuesEqual(right, left, seen)
+		}
+
+		right, ok := toBigInt(right)
+		return ok && left.Value.Cmp(right) == 0
+	case *Float:
+		if right, ok := right.(*Float); ok {
+			return left.Value == right.Value
+		}
+
+		return floatEqualsInteger(left.Value, right)
+	case *object.String:
+		right, ok := right.(*object.String)
+		return ok && left.Value == right.Value
+	case *object.Boolean:
+		right, ok := right.(*object.Boolean)
+		return ok && left.Value == right.Value
+	case *object.Null:
+		_, ok := right.(*object.Null)
+		return ok
+	case *object.Array:
+		right, ok := right.(*object.Array)
+		if !ok || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+
+		if left == right || seen[[2]object.Object{left, right}] {
+			return true
+		}
+
+		seen = markSeen(seen, left, right)
+
+		for i := range left.Elements {
+			if !valuesEqual(left.Elements[i], right.Elements[i], seen) {
+				return false
+			}
+		}
+
+		return true
+	case *object.HashMap:
+		right, ok := right.(*object.HashMap)
+		if !ok || len(left.Pairs) != len(right.Pairs) {
+			return false
+		}
+
+		if left == right || seen[[2]object.Object{left, right}] {
+			return true
+		}
+
+		seen = markSeen(seen, left, right)
+
+		for key, pair := range left.Pairs {
+			other, ok := right.Pairs[key]
+			if !ok || !valuesEqual(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return left == right
+}
+
+func markSeen(seen map[[2]object.Object]bool, left object.Object, right object.Object) map[[2]object.Object]bool {
+	if seen == nil {
+		seen = map[[2]object.Object]bool{}
 	}
+
+	seen[[2]object.Object{left, right}] = true
+
+	return seen
 }
 
 func getBoolean(input bool) *object.Boolean {
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrCancelled = errors.New("execution cancelled")
+var ErrDeadlineExceeded = errors.New("execution deadline exceeded")
+
+func checkContext(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrDeadlineExceeded
+		}
+
+		return ErrCancelled
+	default:
+		return nil
+	}
+}
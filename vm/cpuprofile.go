@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CPUSample is a Loop call stack, innermost frame first, together with the amount of times it was sampled.
+type CPUSample struct {
+	Stack []StackFrame
+	Count int64
+}
+
+// CPUProfile holds the call stacks sampled between StartCPUProfile and StopCPUProfile.
+type CPUProfile struct {
+	Start    time.Time
+	Duration time.Duration
+	Interval time.Duration
+	Samples  []CPUSample
+}
+
+// sampleCheckInterval is the amount of instructions between two reads of the clock while sampling.
+const sampleCheckInterval = 64
+
+type sampler struct {
+	profile   *CPUProfile
+	samples   map[string]int
+	next      time.Time
+	countdown int
+}
+
+// StartCPUProfile samples the Loop call stack every interval until StopCPUProfile is called.
+// The clock is only read every few instructions, time spent in a builtin function is attributed to the stack of the
+// instruction that runs after it.
+func (vm *VM) StartCPUProfile(interval time.Duration) {
+	if vm.sampler != nil {
+		return
+	}
+
+	now := time.Now()
+
+	vm.sampler = &sampler{
+		profile:   &CPUProfile{Start: now, Interval: interval},
+		samples:   map[string]int{},
+		next:      now.Add(interval),
+		countdown: sampleCheckInterval,
+	}
+}
+
+// StopCPUProfile stops sampling and returns the profile, or nil when no profile was started.
+func (vm *VM) StopCPUProfile() *CPUProfile {
+	s := vm.sampler
+	if s == nil {
+		return nil
+	}
+
+	vm.sampler = nil
+
+	s.profile.Duration = time.Since(s.profile.Start)
+
+	return s.profile
+}
+
+// resume skips the time in which the VM wasn't running, so it doesn't end up in the first sample of the next Run.
+func (s *sampler) resume() {
+	now := time.Now()
+
+	if s.next.Before(now) {
+		s.next = now.Add(s.profile.Interval)
+	}
+}
+
+// sample records the current call stack once for every interval which passed since the previous sample.
+func (vm *VM) sample(ip int) {
+	s := vm.sampler
+
+	s.countdown--
+	if s.countdown > 0 {
+		return
+	}
+
+	s.countdown = sampleCheckInterval
+
+	now := time.Now()
+	if now.Before(s.next) {
+		return
+	}
+
+	ticks := int64(now.Sub(s.next)/s.profile.Interval) + 1
+	s.next = s.next.Add(time.Duration(ticks) * s.profile.Interval)
+
+	stack := vm.backtrace(ip)
+
+	var key strings.Builder
+	for _, frame := range stack {
+		fmt.Fprintf(&key, "%d:%d;", frame.FunctionId, frame.Ip)
+	}
+
+	index, ok := s.samples[key.String()]
+	if !ok {
+		index = len(s.profile.Samples)
+		s.samples[key.String()] = index
+		s.profile.Samples = append(s.profile.Samples, CPUSample{Stack: stack})
+	}
+
+	s.profile.Samples[index].Count += ticks
+}
@@ -0,0 +1,216 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+	"strings"
+)
+
+var ErrDivisionByZero = errors.New("division by zero")
+
+// TypeError is returned when an opcode doesn't support the types of its operands.
+type TypeError struct {
+	OpCode code.OpCode
+	Types  []string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("unsupported operand types for %s: %s", OpcodeName(e.OpCode), strings.Join(e.Types, ", "))
+}
+
+// IndexError is returned when an array is assigned to outside of its elements.
+type IndexError struct {
+	Index  int64
+	Length int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index out of range. index=%d. length=%d", e.Index, e.Length)
+}
+
+// UndefinedGlobalError is returned when a global is read before anything was stored in it.
+type UndefinedGlobalError struct {
+	Index int
+}
+
+func (e *UndefinedGlobalError) Error() string {
+	return fmt.Sprintf("undefined global. index=%d", e.Index)
+}
+
+// ArgumentCountError is returned when a function is called with the wrong amount of arguments.
+type ArgumentCountError struct {
+	Expected int
+	Got      int
+}
+
+func (e *ArgumentCountError) Error() string {
+	return fmt.Sprintf("wrong number of arguments. expected=%d. got=%d", e.Expected, e.Got)
+}
+
+// NotCallableError is returned when a value which isn't a function is called.
+type NotCallableError struct {
+	Type string
+}
+
+func (e *NotCallableError) Error() string {
+	return fmt.Sprintf("attempt to call non-function. got=%q", e.Type)
+}
+
+// StackOverflowError is returned when a call needs more than MaxFrames frames or more than StackSize values.
+type StackOverflowError struct {
+	Frames bool
+}
+
+func (e *StackOverflowError) Error() string {
+	if e.Frames {
+		return fmt.Sprintf("stack overflow. exceeded %d frames", MaxFrames)
+	}
+
+	return fmt.Sprintf("stack overflow. exceeded %d values", StackSize)
+}
+
+// OutOfRangeError is returned when an operand refers to a constant, local, free variable or builtin function which
+// doesn't exist. Verify rejects such bytecode before it runs.
+type OutOfRangeError struct {
+	Kind   string
+	Index  int
+	Length int
+}
+
+func (e *OutOfRangeError) Error() string {
+	return fmt.Sprintf("%s out of range. index=%d. length=%d", e.Kind, e.Index, e.Length)
+}
+
+// PanicError holds a Go panic which occurred while executing bytecode.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("vm panic: %v", e.Value)
+}
+
+// StackFrame is a single frame of a Loop backtrace, Function is nil for the main program.
+// Position is only set when a source map is attached to the VM.
+type StackFrame struct {
+	FunctionId int
+	Ip         int
+	Function   *object.CompiledFunction
+	Position   *SourcePosition
+}
+
+// RuntimeError wraps every error returned by Run with the opcode that failed and the Loop call stack at that moment.
+type RuntimeError struct {
+	OpCode code.OpCode
+	Ip     int
+	Trace  []StackFrame // Innermost frame first
+	Err    error
+}
+
+func (e *RuntimeError) Error() string {
+	if position := e.Position(); position != nil {
+		return fmt.Sprintf("%s: %s", position, e.Err)
+	}
+
+	return e.Err.Error()
+}
+
+// Position returns the source position of the failing instruction, or nil when it is unknown.
+func (e *RuntimeError) Position() *SourcePosition {
+	if len(e.Trace) == 0 {
+		return nil
+	}
+
+	return e.Trace[0].Position
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// StackTrace renders the error followed by the backtrace, one frame per line.
+func (e *RuntimeError) StackTrace() string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "runtime error: %s\n", e)
+	fmt.Fprintf(&out, "  during %s\n", OpcodeName(e.OpCode))
+
+	for _, frame := range e.Trace {
+		fmt.Fprintf(&out, "    at %s\n", frame)
+	}
+
+	return out.String()
+}
+
+func (f StackFrame) String() string {
+	name := "main"
+	if f.Function != nil {
+		name = fmt.Sprintf("function %d", f.FunctionId)
+	}
+
+	if f.Position != nil {
+		return fmt.Sprintf("%s (ip=%d) %s", name, f.Ip, f.Position)
+	}
+
+	return fmt.Sprintf("%s (ip=%d)", name, f.Ip)
+}
+
+// OpcodeName returns the mnemonic of an opcode.
+func OpcodeName(op code.OpCode) string {
+	// The compiler's definition of OpIndex is named "OpHash"
+	if op == code.OpIndex {
+		return "OpIndex"
+	}
+
+	def, err := Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("OpCode(%d)", op)
+	}
+
+	return def.Name
+}
+
+func (vm *VM) runtimeError(err error) *RuntimeError {
+	return &RuntimeError{
+		OpCode: vm.lastOp,
+		Ip:     vm.lastIp,
+		Trace:  vm.backtrace(vm.lastIp),
+		Err:    err,
+	}
+}
+
+// backtrace walks the active frames, ip is used for the innermost frame. Every other frame is halted on the OpCall
+// that created the frame above it, with its ip pointing at the operand.
+func (vm *VM) backtrace(ip int) []StackFrame {
+	trace := make([]StackFrame, 0, vm.frameIndex)
+
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		frameIp := frame.ip - 1
+		if i == vm.frameIndex-1 {
+			frameIp = ip
+		}
+
+		var fn *object.CompiledFunction
+		if i != 0 {
+			fn = frame.closure.Fn
+		}
+
+		stackFrame := StackFrame{
+			FunctionId: vm.FunctionId(frame.closure.Fn),
+			Ip:         frameIp,
+			Function:   fn,
+		}
+
+		if position, ok := vm.Position(fn, frameIp); ok {
+			stackFrame.Position = &position
+		}
+
+		trace = append(trace, stackFrame)
+	}
+
+	return trace
+}
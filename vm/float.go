@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"encoding/gob"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const FLOAT = "FLOAT"
+
+// Float is a 64 bit floating point number. Arithmetic between a float and an integer results in a float.
+//
+// The compiler has no float literals yet, so floats only get into bytecode as constants written by a front end which
+// creates them itself. Such a file is read after calling RegisterGobTypes, every other float is the result of
+// arithmetic on those constants.
+type Float struct {
+	Value float64
+}
+
+// RegisterGobTypes registers the constant types of the VM with gob, in addition to compiler.RegisterGobTypes.
+func RegisterGobTypes() {
+	gob.Register(&Float{})
+}
+
+func (f *Float) Type() string {
+	return FLOAT
+}
+
+func (f *Float) Inspect() string {
+	return formatFloat(f.Value)
+}
+
+// Hash uses the hash of the bits, prefixed so it doesn't collide with a string. A whole number hashes like the integer
+// it is equal to, so a hashmap finds the same value for 1 and 1.0. -0 is equal to 0 so it hashes like 0, and every NaN
+// hashes the same so a NaN key can be looked up again even though NaN isn't equal to itself.
+func (f *Float) Hash() object.HashKey {
+	value := f.Value
+
+	switch {
+	case math.IsNaN(value):
+		value = math.NaN()
+	case value >= math.MinInt64 && value < math.MaxInt64 && value == math.Trunc(value):
+		return (&object.Integer{Value: int64(value)}).Hash()
+	case !math.IsInf(value, 0) && value == math.Trunc(value):
+		integer, _ := big.NewFloat(value).Int(nil)
+		return (&BigInteger{Value: integer}).Hash()
+	}
+
+	return (&object.String{Value: "\x00float:" + strconv.FormatUint(math.Float64bits(value), 16)}).Hash()
+}
+
+// formatFloat returns the shortest representation which reads back as the same value, whole numbers keep a ".0"
+// so they can't be mistaken for integers.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "Infinity"
+	case math.IsInf(value, -1):
+		return "-Infinity"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	formatted := strconv.FormatFloat(value, 'g', -1, 64)
+
+	if !strings.ContainsAny(formatted, ".eE") {
+		formatted += ".0"
+	}
+
+	return formatted
+}
+
+// floatArithmetic executes an arithmetic opcode on two floats, unlike integer division nothing is truncated. Negative
+// exponents are allowed.
+func floatArithmetic(op code.OpCode, left float64, right float64) (object.Object, error) {
+	switch op {
+	case code.OpAdd:
+		return &Float{Value: left + right}, nil
+	case code.OpSubtract:
+		return &Float{Value: left - right}, nil
+	case code.OpMultiply:
+		return &Float{Value: left * right}, nil
+	case code.OpDivide:
+		if right == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		return &Float{Value: left / right}, nil
+	case OpModulo:
+		if right == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		result := math.Mod(left, right)
+		if result != 0 && (result < 0) != (right < 0) {
+			result += right
+		}
+
+		return &Float{Value: result}, nil
+	case OpPower:
+		return &Float{Value: math.Pow(left, right)}, nil
+	}
+
+	return nil, &TypeError{OpCode: op, Types: []string{FLOAT, FLOAT}}
+}
+
+// toFloat returns the value of any number as a float.
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Float:
+		return obj.Value, true
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *BigInteger:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value, true
+	}
+
+	return 0, false
+}
+
+// floatEqualsInteger compares a float with an integer or big integer exactly, the integer isn't rounded to a float
+// first. Equal values hash the same, see Float.Hash.
+func floatEqualsInteger(value float64, integer object.Object) bool {
+	if math.IsInf(value, 0) || value != math.Trunc(value) {
+		return false
+	}
+
+	right, ok := toBigInt(integer)
+	if !ok {
+		return false
+	}
+
+	left, _ := big.NewFloat(value).Int(nil)
+
+	return left.Cmp(right) == 0
+}
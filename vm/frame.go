@@ -9,6 +9,12 @@ type Frame struct {
 	closure     *object.Closure
 	ip          int
 	basePointer int
+
+	profile *activation
+	traced  bool
+
+	memoKey    MemoizedKey
+	memoResult *MemoizedFunction
 }
 
 func NewFrame(fn *object.Closure, basePointer int) *Frame {
@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+)
+
+// DefaultOpcodeCosts holds the fuel cost of opcodes which are more expensive than a single unit.
+var DefaultOpcodeCosts = map[code.OpCode]uint64{
+	code.OpCall:    10,
+	code.OpClosure: 5,
+	code.OpHash:    5,
+	code.OpArray:   3,
+	code.OpIndex:   2,
+	OpPower:        3,
+}
+
+type OutOfFuelError struct {
+	Limit    uint64
+	Executed uint64
+}
+
+func (e *OutOfFuelError) Error() string {
+	return fmt.Sprintf("out of fuel. limit=%d. executed=%d instructions", e.Limit, e.Executed)
+}
+
+// SetFuel enables fuel metering, Run will stop with an *OutOfFuelError once the opcode costs exceed the limit.
+func (vm *VM) SetFuel(limit uint64) {
+	vm.fuelLimit = limit
+	vm.fuelUsed = 0
+	vm.meterFuel = true
+
+	vm.initOpcodeCosts()
+}
+
+// SetOpcodeCost overrides the fuel cost of a single opcode, it can be called before or after SetFuel.
+func (vm *VM) SetOpcodeCost(op code.OpCode, cost uint64) {
+	vm.initOpcodeCosts()
+	vm.opcodeCosts[op] = cost
+}
+
+// initOpcodeCosts fills the cost table with the default costs the first time it is needed.
+func (vm *VM) initOpcodeCosts() {
+	if vm.opcodeCosts != nil {
+		return
+	}
+
+	vm.opcodeCosts = make([]uint64, 256)
+
+	for i := range vm.opcodeCosts {
+		vm.opcodeCosts[i] = 1
+	}
+
+	for op, cost := range DefaultOpcodeCosts {
+		vm.opcodeCosts[op] = cost
+	}
+}
+
+func (vm *VM) FuelUsed() uint64 {
+	return vm.fuelUsed
+}
+
+func (vm *VM) InstructionsExecuted() uint64 {
+	return vm.executed
+}
+
+func (vm *VM) consumeFuel(op code.OpCode) error {
+	cost := vm.opcodeCosts[op]
+
+	if vm.fuelUsed+cost > vm.fuelLimit {
+		return &OutOfFuelError{Limit: vm.fuelLimit, Executed: vm.executed}
+	}
+
+	vm.fuelUsed += cost
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+)
+
+// InstructionHook is called before every instruction is executed, returning an error stops Run with that error.
+type InstructionHook func(op code.OpCode) error
+
+func (vm *VM) SetInstructionHook(hook InstructionHook) {
+	vm.hook = hook
+}
+
+// Location returns the function and instruction offset that is about to be executed, fn is nil for the main program.
+func (vm *VM) Location() (*object.CompiledFunction, int) {
+	frame := vm.currentFrame()
+
+	if vm.frameIndex == 1 {
+		return nil, frame.ip
+	}
+
+	return frame.closure.Fn, frame.ip
+}
+
+// Depth returns the amount of active frames, including the main program.
+func (vm *VM) Depth() int {
+	return vm.frameIndex
+}
+
+// Backtrace returns the active frames, innermost first.
+func (vm *VM) Backtrace() []StackFrame {
+	return vm.backtrace(vm.currentFrame().ip)
+}
+
+// Stack returns a copy of the operand stack, the top of the stack is the last element.
+func (vm *VM) Stack() []object.Object {
+	stack := make([]object.Object, vm.sp)
+	copy(stack, vm.stack[:vm.sp])
+
+	return stack
+}
+
+// Locals returns the locals of a frame, frames are numbered like Backtrace with 0 being the innermost frame.
+// Parameters are the first locals of a function.
+func (vm *VM) Locals(frame int) []object.Object {
+	f := vm.frameAt(frame)
+	if f == nil {
+		return nil
+	}
+
+	numLocals := f.closure.Fn.NumLocals
+	if f.basePointer+numLocals > StackSize {
+		return nil
+	}
+
+	locals := make([]object.Object, numLocals)
+	copy(locals, vm.stack[f.basePointer:f.basePointer+numLocals])
+
+	return locals
+}
+
+// Free returns the free variables captured by the closure of a frame.
+func (vm *VM) Free(frame int) []object.Object {
+	f := vm.frameAt(frame)
+	if f == nil {
+		return nil
+	}
+
+	return f.closure.Free
+}
+
+// Globals returns every global that has been set, by index.
+func (vm *VM) Globals() map[int]object.Object {
+	return setValues(vm.globals)
+}
+
+// Variables returns every variable declared with "var" that has been set, by index.
+func (vm *VM) Variables() map[int]object.Object {
+	return setValues(vm.variables)
+}
+
+func (vm *VM) frameAt(frame int) *Frame {
+	index := vm.frameIndex - 1 - frame
+	if frame < 0 || index < 0 {
+		return nil
+	}
+
+	return vm.frames[index]
+}
+
+func setValues(store []object.Object) map[int]object.Object {
+	values := map[int]object.Object{}
+
+	for i, value := range store {
+		if value != nil {
+			values[i] = value
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+)
+
+// instruction is a single decoded instruction of a function.
+type instruction struct {
+	offset   int
+	op       code.OpCode
+	operands []int
+	width    int
+}
+
+func decodeInstructions(ins code.Instructions) ([]instruction, *VerifyError) {
+	var decoded []instruction
+
+	for offset := 0; offset < len(ins); {
+		op := code.OpCode(ins[offset])
+
+		def, err := Lookup(byte(op))
+		if err != nil {
+			return decoded, &VerifyError{Offset: offset, Message: err.Error()}
+		}
+
+		width := 1
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+
+		if offset+width > len(ins) {
+			return decoded, &VerifyError{Offset: offset, Message: fmt.Sprintf("truncated operands for %s", OpcodeName(op))}
+		}
+
+		operands, _ := code.ReadOperands(def, ins[offset+1:])
+
+		decoded = append(decoded, instruction{
+			offset:   offset,
+			op:       op,
+			operands: operands,
+			width:    width,
+		})
+
+		offset += width
+	}
+
+	return decoded, nil
+}
+
+// stackEffect returns how many values an instruction pops from and pushes onto the stack.
+func stackEffect(op code.OpCode, operands []int) (int, int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal,
+		code.OpGetBuiltinFunction, code.OpGetFree, code.OpGetVar:
+		return 0, 1
+	case code.OpAdd, code.OpMultiply, code.OpDivide, code.OpSubtract, code.OpEquals, code.OpNotEquals,
+		code.OpGreaterThan, code.OpIndex, OpModulo, OpPower:
+		return 2, 1
+	case code.OpPop, code.OpJumpIfNotTrue, code.OpSetGlobal, code.OpSetLocal, code.OpSetVar:
+		return 1, 0
+	case code.OpArray, code.OpHash:
+		return operands[0], 1
+	case code.OpCall:
+		return operands[0] + 1, 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpReturnValue:
+		return 1, 0
+	case code.OpSetIndex:
+		return 3, 0
+	}
+
+	return 0, 0
+}
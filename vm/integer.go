@@ -0,0 +1,210 @@
+package vm
+
+import (
+	"errors"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+	"math"
+	"math/big"
+)
+
+var ErrIntegerOverflow = errors.New("integer overflow")
+var ErrNegativeExponent = errors.New("negative exponent")
+
+// maxPowerBits limits the size of a promoted power, a bigger result is reported as an overflow
+const maxPowerBits = 1 << 20
+
+// IntegerMode decides what happens when integer arithmetic doesn't fit in 64 bits.
+type IntegerMode int
+
+const (
+	// IntegerWrap wraps around like Go's int64, this is the default
+	IntegerWrap IntegerMode = iota
+	// IntegerChecked stops Run with ErrIntegerOverflow
+	IntegerChecked
+	// IntegerPromote continues with a *BigInteger
+	IntegerPromote
+)
+
+const BIGINTEGER = "BIGINTEGER"
+
+// BigInteger is an integer outside of the int64 range, it only exists when the VM runs with IntegerPromote.
+// Results which fit in an int64 again are turned back into an *object.Integer.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (b *BigInteger) Type() string {
+	return BIGINTEGER
+}
+
+func (b *BigInteger) Inspect() string {
+	return b.Value.String()
+}
+
+// Hash uses the hash of the digits, prefixed so it doesn't collide with a string containing the same digits.
+func (b *BigInteger) Hash() object.HashKey {
+	return (&object.String{Value: "\x00bigint:" + b.Value.String()}).Hash()
+}
+
+// SetIntegerMode selects how integer overflow is handled, see IntegerMode.
+func (vm *VM) SetIntegerMode(mode IntegerMode) {
+	vm.integerMode = mode
+}
+
+// integerArithmetic executes an arithmetic opcode on two int64 values, falling back to big integers on overflow
+// when the VM promotes integers.
+func (vm *VM) integerArithmetic(op code.OpCode, left int64, right int64) (object.Object, error) {
+	var result int64
+	var overflow bool
+
+	switch op {
+	case code.OpAdd:
+		result = left + right
+		overflow = (left >= 0) == (right >= 0) && (result >= 0) != (left >= 0)
+	case code.OpSubtract:
+		result = left - right
+		overflow = (left >= 0) != (right >= 0) && (result >= 0) != (left >= 0)
+	case code.OpMultiply:
+		result = left * right
+		overflow = multiplyOverflows(left, right, result)
+	case code.OpDivide:
+		if right == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		result = left / right
+		overflow = left == math.MinInt64 && right == -1
+	case OpModulo:
+		if right == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		result = left % right
+		if result != 0 && (result < 0) != (right < 0) {
+			result += right
+		}
+	case OpPower:
+		if right < 0 {
+			return nil, ErrNegativeExponent
+		}
+
+		result, overflow = power(left, right)
+	default:
+		return nil, &TypeError{OpCode: op, Types: []string{object.INTEGER, object.INTEGER}}
+	}
+
+	if !overflow || vm.integerMode == IntegerWrap {
+		return &object.Integer{Value: result}, nil
+	}
+
+	if vm.integerMode == IntegerChecked {
+		return nil, ErrIntegerOverflow
+	}
+
+	return bigArithmetic(op, big.NewInt(left), big.NewInt(right))
+}
+
+// multiplyOverflows reports whether result is the wrapped around product of left and right.
+func multiplyOverflows(left int64, right int64, result int64) bool {
+	return left != 0 && (result/left != right || (left == -1 && right == math.MinInt64))
+}
+
+// power raises base to a non negative exponent by squaring, the result wraps around on overflow.
+func power(base int64, exponent int64) (int64, bool) {
+	result := int64(1)
+	overflow := false
+
+	for exponent > 0 {
+		if exponent&1 == 1 {
+			next := result * base
+			overflow = overflow || multiplyOverflows(result, base, next)
+			result = next
+		}
+
+		exponent >>= 1
+
+		// The squared base is always used by a higher bit of the exponent, so its overflow is the result's
+		if exponent > 0 {
+			next := base * base
+			overflow = overflow || multiplyOverflows(base, base, next)
+			base = next
+		}
+	}
+
+	return result, overflow
+}
+
+// bigArithmetic executes an arithmetic opcode on big integers, division truncates towards zero like it does for
+// int64 values and the remainder is floored like it is for int64 values.
+func bigArithmetic(op code.OpCode, left *big.Int, right *big.Int) (object.Object, error) {
+	result := new(big.Int)
+
+	switch op {
+	case code.OpAdd:
+		result.Add(left, right)
+	case code.OpSubtract:
+		result.Sub(left, right)
+	case code.OpMultiply:
+		result.Mul(left, right)
+	case code.OpDivide:
+		if right.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		result.Quo(left, right)
+	case OpModulo:
+		if right.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+
+		result.Rem(left, right)
+		if result.Sign() != 0 && result.Sign() != right.Sign() {
+			result.Add(result, right)
+		}
+	case OpPower:
+		if right.Sign() < 0 {
+			return nil, ErrNegativeExponent
+		}
+
+		if left.CmpAbs(big.NewInt(1)) > 0 && (!right.IsInt64() || right.Int64() > maxPowerBits/int64(left.BitLen())) {
+			return nil, ErrIntegerOverflow
+		}
+
+		result.Exp(left, right, nil)
+	default:
+		return nil, &TypeError{OpCode: op, Types: []string{BIGINTEGER, BIGINTEGER}}
+	}
+
+	return normalizeBigInteger(result), nil
+}
+
+func normalizeBigInteger(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+
+	return &BigInteger{Value: value}
+}
+
+// isInteger reports whether obj is an integer or a big integer.
+func isInteger(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *BigInteger:
+		return true
+	}
+
+	return false
+}
+
+// toBigInt returns the value of an integer or big integer.
+func toBigInt(obj object.Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInteger:
+		return obj.Value, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,364 @@
+package vm
+
+import (
+	"container/heap"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"sync"
+)
+
+// MaxMemoizedArgs is the highest amount of arguments a call can have to be memoized.
+const MaxMemoizedArgs = 8
+
+type MemoizedFunction struct {
+	Id     int
+	Args   []object.Object
+	Result object.Object
+}
+
+// MemoizedKey identifies a call by the function id and the hashes of its arguments.
+type MemoizedKey struct {
+	Id      int
+	NumArgs int
+	Args    [MaxMemoizedArgs]object.HashKey
+}
+
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the result which was used least recently
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the result which was used least often, ties are broken by recency
+	EvictLFU
+)
+
+// MemoCacheOptions configures a bounded cache, a limit of zero means no limit.
+// MaxBytes is compared against an estimate of the memory used by the arguments and results.
+type MemoCacheOptions struct {
+	Policy     EvictionPolicy
+	MaxEntries int
+	MaxBytes   int
+}
+
+// MemoStats counts the lookups of a cache. Results which are too big for the cache are counted as an eviction.
+type MemoStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// MemoCache holds the results of memoized function calls. A cache can be shared by VMs running the same bytecode,
+// function ids of different programs overlap.
+type MemoCache struct {
+	mu      sync.Mutex
+	options MemoCacheOptions
+	results map[MemoizedKey]*memoEntry
+	queue   evictionQueue
+	bytes   int
+	clock   uint64
+	stats   MemoStats
+}
+
+type memoEntry struct {
+	key     MemoizedKey
+	result  *MemoizedFunction
+	size    int
+	uses    uint64
+	lastUse uint64
+	index   int
+}
+
+// NewMemoCache creates a cache without limits.
+func NewMemoCache() *MemoCache {
+	return NewBoundedMemoCache(MemoCacheOptions{})
+}
+
+func NewBoundedMemoCache(options MemoCacheOptions) *MemoCache {
+	return &MemoCache{
+		options: options,
+		results: map[MemoizedKey]*memoEntry{},
+		queue:   evictionQueue{policy: options.Policy},
+	}
+}
+
+// Len returns the amount of stored results.
+func (c *MemoCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.results)
+}
+
+// Bytes returns the estimated size of the stored results.
+func (c *MemoCache) Bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// Stats returns the counters of every VM using the cache.
+func (c *MemoCache) Stats() MemoStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// get returns the result of a call. The key only holds the hashes of the arguments, so a result which was stored for
+// other arguments with the same hashes is a miss.
+func (c *MemoCache) get(key MemoizedKey, args []object.Object) *MemoizedFunction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.results[key]
+	if !ok || !sameArgs(entry.result.Args, args) {
+		c.stats.Misses++
+		return nil
+	}
+
+	c.clock++
+	entry.uses++
+	entry.lastUse = c.clock
+	heap.Fix(&c.queue, entry.index)
+
+	c.stats.Hits++
+
+	return entry.result
+}
+
+// store adds a result and returns the amount of results which were evicted to make room for it.
+func (c *MemoCache) store(key MemoizedKey, result *MemoizedFunction) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.results[key]; ok {
+		c.remove(old)
+	}
+
+	size := memoEntrySize(result)
+
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		c.stats.Evictions++
+		return 1
+	}
+
+	var evicted uint64
+
+	for len(c.queue.entries) > 0 && c.full(size) {
+		c.remove(c.queue.entries[0])
+		evicted++
+	}
+
+	c.clock++
+
+	entry := &memoEntry{key: key, result: result, size: size, uses: 1, lastUse: c.clock}
+	c.results[key] = entry
+	c.bytes += size
+	heap.Push(&c.queue, entry)
+
+	c.stats.Evictions += evicted
+
+	return evicted
+}
+
+// Clear removes every stored result, the counters are kept.
+func (c *MemoCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = map[MemoizedKey]*memoEntry{}
+	c.queue.entries = nil
+	c.bytes = 0
+}
+
+// full reports whether an entry of the given size has to evict other entries first.
+func (c *MemoCache) full(size int) bool {
+	if c.options.MaxEntries > 0 && len(c.results) >= c.options.MaxEntries {
+		return true
+	}
+
+	return c.options.MaxBytes > 0 && c.bytes+size > c.options.MaxBytes
+}
+
+func (c *MemoCache) remove(entry *memoEntry) {
+	heap.Remove(&c.queue, entry.index)
+	delete(c.results, entry.key)
+	c.bytes -= entry.size
+}
+
+// evictionQueue is a heap with the entry that should be evicted first at the top.
+type evictionQueue struct {
+	policy  EvictionPolicy
+	entries []*memoEntry
+}
+
+func (q evictionQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q evictionQueue) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+
+	if q.policy == EvictLFU && a.uses != b.uses {
+		return a.uses < b.uses
+	}
+
+	return a.lastUse < b.lastUse
+}
+
+func (q evictionQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *evictionQueue) Push(x interface{}) {
+	entry := x.(*memoEntry)
+	entry.index = len(q.entries)
+	q.entries = append(q.entries, entry)
+}
+
+func (q *evictionQueue) Pop() interface{} {
+	last := len(q.entries) - 1
+	entry := q.entries[last]
+	q.entries[last] = nil
+	q.entries = q.entries[:last]
+
+	return entry
+}
+
+// memoizedKey hashes the arguments of a call, calls with arguments that aren't hashable can't be memoized.
+func memoizedKey(id int, args []object.Object) (MemoizedKey, bool) {
+	key := MemoizedKey{Id: id, NumArgs: len(args)}
+
+	if len(args) > MaxMemoizedArgs {
+		return key, false
+	}
+
+	for i, arg := range args {
+		hashable, ok := arg.(object.Hashable)
+		if !ok {
+			return key, false
+		}
+
+		key.Args[i] = hashable.Hash()
+	}
+
+	return key, true
+}
+
+// sameArgs reports whether two calls have arguments of the same types and values.
+func sameArgs(a []object.Object, b []object.Object) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Type() != b[i].Type() || !valuesEqual(a[i], b[i], nil) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyValue returns a copy of the values which OpSetLocal changes in place, so reassigning a variable can't change the
+// arguments or result of a cached call. Other values are returned as they are, arrays and hashmaps are never cached.
+func copyValue(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: obj.Value}
+	case *object.String:
+		return &object.String{Value: obj.Value}
+	case *object.Boolean:
+		return &object.Boolean{Value: obj.Value}
+	case *BigInteger:
+		return &BigInteger{Value: obj.Value}
+	case *Float:
+		return &Float{Value: obj.Value}
+	}
+
+	return obj
+}
+
+// memoEntrySize estimates the memory used by a cached result, including the key and bookkeeping.
+func memoEntrySize(result *MemoizedFunction) int {
+	size := 128
+
+	for _, arg := range result.Args {
+		size += objectSize(arg, 0)
+	}
+
+	return size + objectSize(result.Result, 0)
+}
+
+func objectSize(obj object.Object, depth int) int {
+	// Cyclic values can't be measured, the depth limit keeps the estimate finite
+	if depth > 16 {
+		return 0
+	}
+
+	switch obj := obj.(type) {
+	case *object.String:
+		return 32 + len(obj.Value)
+	case *object.Array:
+		size := 40
+
+		for _, element := range obj.Elements {
+			size += 16 + objectSize(element, depth+1)
+		}
+
+		return size
+	case *object.HashMap:
+		size := 48
+
+		for _, pair := range obj.Pairs {
+			size += 64 + objectSize(pair.Key, depth+1) + objectSize(pair.Value, depth+1)
+		}
+
+		return size
+	default:
+		return 16
+	}
+}
+
+// SetMemoCache enables memoization of function calls using the given cache, nil disables memoization.
+// Only calls to functions which AnalyzePurity classifies as pure are memoized.
+func (vm *VM) SetMemoCache(cache *MemoCache) {
+	vm.memo = cache
+
+	if cache != nil && vm.purity == nil {
+		vm.purity = AnalyzePurity(&compiler.Bytecode{
+			Instructions: vm.frames[0].Instructions(),
+			Constants:    vm.constants,
+		})
+	}
+}
+
+// SetPurity replaces the classification which decides which calls are memoized, like the report of a PurityAnalyzer
+// that is kept up to date with the bytecode. It has to be called before SetMemoCache, which analyzes the bytecode
+// itself when no classification was set.
+func (vm *VM) SetPurity(report *PurityReport) {
+	vm.purity = report
+}
+
+// EnableMemoization enables memoization with a cache which is private to this VM.
+func (vm *VM) EnableMemoization() {
+	vm.SetMemoCache(NewMemoCache())
+}
+
+// MemoCache returns the cache used for memoization, or nil when memoization is disabled.
+func (vm *VM) MemoCache() *MemoCache {
+	return vm.memo
+}
+
+// MemoStats returns the counters of the calls made by this VM, see MemoCache.Stats for the counters of a shared cache.
+func (vm *VM) MemoStats() MemoStats {
+	return vm.memoStats
+}
+
+// Purity returns the classification used to decide which calls are memoized, or nil when memoization was never enabled.
+func (vm *VM) Purity() *PurityReport {
+	return vm.purity
+}
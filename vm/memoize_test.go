@@ -0,0 +1,162 @@
+package vm
+
+import (
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"testing"
+)
+
+func TestMemoCache_Eviction(t *testing.T) {
+	tests := []struct {
+		options  MemoCacheOptions
+		stores   []int64
+		gets     []int64
+		expected []int64
+	}{
+		// 1 is used most recently, so 2 is evicted
+		{MemoCacheOptions{Policy: EvictLRU, MaxEntries: 2}, []int64{1, 2, 3}, []int64{1}, []int64{1, 3}},
+		// 1 is used most often, 2 and 3 are tied and 2 was used least recently
+		{MemoCacheOptions{Policy: EvictLFU, MaxEntries: 2}, []int64{1, 2, 3}, []int64{1, 1}, []int64{1, 3}},
+		{MemoCacheOptions{Policy: EvictLFU, MaxEntries: 3}, []int64{1, 2, 3, 4}, []int64{2, 1, 1}, []int64{1, 2, 4}},
+		{MemoCacheOptions{MaxBytes: 3 * 160}, []int64{1, 2, 3, 4}, nil, []int64{2, 3, 4}},
+		{MemoCacheOptions{}, []int64{1, 2, 3, 4}, nil, []int64{1, 2, 3, 4}},
+	}
+
+	for i, tt := range tests {
+		cache := NewBoundedMemoCache(tt.options)
+
+		for j, value := range tt.stores {
+			cache.store(testMemoKey(value), testMemoResult(value))
+
+			// Stores and gets are interleaved, so every get happens after the first two stores
+			if j == 1 {
+				for _, get := range tt.gets {
+					if cache.get(testMemoKey(get), testMemoArgs(get)) == nil {
+						t.Fatalf("test %d: expected %d to be cached", i, get)
+					}
+				}
+			}
+		}
+
+		if cache.Len() != len(tt.expected) {
+			t.Errorf("test %d: wrong amount of entries. want=%d. got=%d", i, len(tt.expected), cache.Len())
+		}
+
+		for _, value := range tt.expected {
+			if cache.results[testMemoKey(value)] == nil {
+				t.Errorf("test %d: expected %d to be cached", i, value)
+			}
+		}
+
+		stats := cache.Stats()
+		if stats.Evictions != uint64(len(tt.stores)-len(tt.expected)) {
+			t.Errorf("test %d: wrong amount of evictions. got=%d", i, stats.Evictions)
+		}
+
+		if stats.Hits != uint64(len(tt.gets)) {
+			t.Errorf("test %d: wrong amount of hits. want=%d. got=%d", i, len(tt.gets), stats.Hits)
+		}
+	}
+}
+
+func TestMemoCache_Clear(t *testing.T) {
+	cache := NewBoundedMemoCache(MemoCacheOptions{MaxEntries: 2})
+	cache.store(testMemoKey(1), testMemoResult(1))
+	cache.store(testMemoKey(2), testMemoResult(2))
+
+	cache.Clear()
+
+	if cache.Len() != 0 || cache.Bytes() != 0 {
+		t.Fatalf("expected an empty cache. len=%d. bytes=%d", cache.Len(), cache.Bytes())
+	}
+
+	cache.store(testMemoKey(3), testMemoResult(3))
+
+	if cache.get(testMemoKey(3), testMemoArgs(3)) == nil || cache.Stats().Evictions != 0 {
+		t.Errorf("expected the cache to be usable after clearing it")
+	}
+}
+
+func TestMemoCache_HashCollision(t *testing.T) {
+	cache := NewMemoCache()
+
+	// The key only holds the hashes of the arguments, a result stored for other arguments must not be returned
+	cache.store(testMemoKey(1), &MemoizedFunction{Id: 1, Args: testMemoArgs(2), Result: &object.Integer{Value: 4}})
+
+	if cached := cache.get(testMemoKey(1), testMemoArgs(1)); cached != nil {
+		t.Errorf("expected a miss for different arguments. got=%s", cached.Result.Inspect())
+	}
+
+	if cached := cache.get(testMemoKey(1), []object.Object{&Float{Value: 2}}); cached != nil {
+		t.Errorf("expected a miss for arguments of another type. got=%s", cached.Result.Inspect())
+	}
+
+	if cache.get(testMemoKey(1), testMemoArgs(2)) == nil {
+		t.Errorf("expected a hit for the stored arguments")
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("wrong stats. got=%+v", stats)
+	}
+}
+
+func TestMemoCache_TooBig(t *testing.T) {
+	cache := NewBoundedMemoCache(MemoCacheOptions{MaxBytes: 200})
+
+	evicted := cache.store(testMemoKey(1), &MemoizedFunction{Result: &object.String{Value: string(make([]byte, 100))}})
+	if evicted != 1 || cache.Len() != 0 || cache.Bytes() != 0 {
+		t.Errorf("expected the result to be rejected. evicted=%d. entries=%d", evicted, cache.Len())
+	}
+}
+
+func TestVM_MemoStats(t *testing.T) {
+	tests := []struct {
+		input    string
+		options  MemoCacheOptions
+		expected MemoStats
+	}{
+		{"var fib = fun(x) { if (x < 2) { return x }; return fib(x - 1) + fib(x - 2) }; fib(15)", MemoCacheOptions{}, MemoStats{Hits: 13, Misses: 16}},
+		{"var f = fun(a, b) { return a }; f(1, \"a\"); f(1, \"b\"); f(1, \"a\"); f(true, \"a\")", MemoCacheOptions{}, MemoStats{Hits: 1, Misses: 3}},
+		{"var f = fun(x) { return x }; f(1); f(2); f(1)", MemoCacheOptions{MaxEntries: 1}, MemoStats{Misses: 3, Evictions: 2}},
+		// Arrays aren't hashable, the calls aren't looked up at all
+		{"var f = fun(a) { return len(a) }; f([1]) + f([1])", MemoCacheOptions{}, MemoStats{}},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		vm.SetMemoCache(NewBoundedMemoCache(tt.options))
+
+		err = vm.Run(nil)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if vm.MemoStats() != tt.expected {
+			t.Errorf("wrong stats for %q. want=%+v. got=%+v", tt.input, tt.expected, vm.MemoStats())
+		}
+
+		if vm.MemoCache().Stats() != tt.expected {
+			t.Errorf("wrong cache stats for %q. want=%+v. got=%+v", tt.input, tt.expected, vm.MemoCache().Stats())
+		}
+	}
+}
+
+func testMemoKey(value int64) MemoizedKey {
+	key, _ := memoizedKey(1, testMemoArgs(value))
+	return key
+}
+
+func testMemoArgs(value int64) []object.Object {
+	return []object.Object{&object.Integer{Value: value}}
+}
+
+func testMemoResult(value int64) *MemoizedFunction {
+	return &MemoizedFunction{Id: 1, Args: testMemoArgs(value), Result: &object.Integer{Value: value}}
+}
@@ -1,7 +1,7 @@
 package vm
 
 import (
-	"fmt"
+	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/loop/models/object"
 	"strconv"
 )
@@ -18,16 +18,34 @@ func (vm *VM) OpAdd() error {
 		} else if rValue, ok := right.(*object.Integer); ok {
 			vm.push(&object.String{Value: lValue.Value + strconv.FormatInt(rValue.Value, 10)})
 			return nil
+		} else if rValue, ok := right.(*BigInteger); ok {
+			vm.push(&object.String{Value: lValue.Value + rValue.Inspect()})
+			return nil
+		} else if rValue, ok := right.(*Float); ok {
+			vm.push(&object.String{Value: lValue.Value + formatFloat(rValue.Value)})
+			return nil
 		}
 	case *object.Integer:
 		if rValue, ok := right.(*object.String); ok {
 			vm.push(&object.String{Value: strconv.FormatInt(lValue.Value, 10) + rValue.Value})
 			return nil
-		} else if rValue, ok := right.(*object.Integer); ok {
-			vm.push(&object.Integer{Value: rValue.Value + lValue.Value})
+		}
+	case *BigInteger:
+		if rValue, ok := right.(*object.String); ok {
+			vm.push(&object.String{Value: lValue.Inspect() + rValue.Value})
 			return nil
 		}
+	case *Float:
+		if rValue, ok := right.(*object.String); ok {
+			vm.push(&object.String{Value: formatFloat(lValue.Value) + rValue.Value})
+			return nil
+		}
+	}
+
+	result, err := vm.arithmetic(code.OpAdd, left, right)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("unknown operation exception. got=%+v. got=%+v", left, right)
+	return vm.push(result)
 }
@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+)
+
+func (vm *VM) executeArithmetic(op code.OpCode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	result, err := vm.arithmetic(op, left, right)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
+}
+
+// arithmetic executes an arithmetic opcode on two numbers. When either side is a float both sides are promoted to
+// floats.
+func (vm *VM) arithmetic(op code.OpCode, left object.Object, right object.Object) (object.Object, error) {
+	leftObj, leftOk := left.(*object.Integer)
+	rightObj, rightOk := right.(*object.Integer)
+
+	if leftOk && rightOk {
+		return vm.integerArithmetic(op, leftObj.Value, rightObj.Value)
+	}
+
+	leftBig, leftOk := toBigInt(left)
+	rightBig, rightOk := toBigInt(right)
+
+	if leftOk && rightOk {
+		return bigArithmetic(op, leftBig, rightBig)
+	}
+
+	leftFloat, leftOk := toFloat(left)
+	rightFloat, rightOk := toFloat(right)
+
+	if leftOk && rightOk {
+		return floatArithmetic(op, leftFloat, rightFloat)
+	}
+
+	return nil, &TypeError{OpCode: op, Types: []string{left.Type(), right.Type()}}
+}
@@ -1,7 +1,7 @@
 package vm
 
 import (
-	"fmt"
+	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/loop/models/object"
 )
 
@@ -19,7 +19,7 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return nil, fmt.Errorf("incorrect key type: %s", key.Type())
+			return nil, &TypeError{OpCode: code.OpHash, Types: []string{key.Type()}}
 		}
 
 		hashedPairs[hashKey.Hash()] = pair
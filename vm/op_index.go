@@ -1,7 +1,7 @@
 package vm
 
 import (
-	"fmt"
+	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/loop/models/object"
 )
 
@@ -12,7 +12,7 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	case left.Type() == object.HASHMAP:
 		return vm.executeHashIndex(left, index)
 	default:
-		return fmt.Errorf("index operator not supported on: %s", left.Type())
+		return &TypeError{OpCode: code.OpIndex, Types: []string{left.Type(), index.Type()}}
 	}
 }
 
@@ -21,7 +21,7 @@ func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	i := index.(*object.Integer).Value
 	max := int64(len(array.Elements)) - 1
 
-	if i > max {
+	if i < 0 || i > max {
 		return vm.push(Null)
 	}
 
@@ -30,7 +30,13 @@ func (vm *VM) executeArrayIndex(left, index object.Object) error {
 
 func (vm *VM) executeHashIndex(left, index object.Object) error {
 	array := left.(*object.HashMap)
-	i := index.(object.Hashable).Hash()
+
+	hashable, ok := index.(object.Hashable)
+	if !ok {
+		return &TypeError{OpCode: code.OpIndex, Types: []string{left.Type(), index.Type()}}
+	}
+
+	i := hashable.Hash()
 
 	if elem, ok := array.Pairs[i]; ok {
 		return vm.push(elem.Value)
@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+)
+
+// Opcodes which the compiler's code package doesn't define yet. They are numbered from vmOpcodeBase, which keeps them
+// apart from the opcodes the compiler numbers upwards from 0, until the compiler defines and emits them itself. The
+// pinned compiler (v0.5.0) never emits them, so they are only reachable from bytecode which is built by hand.
+const (
+	// OpModulo pops the divisor and the dividend and pushes the floored remainder, which has the sign of the divisor
+	OpModulo code.OpCode = vmOpcodeBase + iota
+	// OpPower pops the exponent and the base and pushes the base raised to the exponent
+	OpPower
+)
+
+// vmOpcodeBase is the first opcode number reserved for the VM.
+const vmOpcodeBase code.OpCode = 0xE0
+
+var definitions = map[code.OpCode]*code.Definition{
+	OpModulo: {Name: "OpModulo", OperandWidths: []int{}},
+	OpPower:  {Name: "OpPower", OperandWidths: []int{}},
+}
+
+// Lookup returns the definition of an opcode of the compiler or the VM.
+func Lookup(op byte) (*code.Definition, error) {
+	if def, ok := definitions[code.OpCode(op)]; ok {
+		return def, nil
+	}
+
+	def, err := code.Lookup(op)
+	if err != nil {
+		return nil, fmt.Errorf("unknown opcode %d", op)
+	}
+
+	return def, nil
+}
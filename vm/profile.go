@@ -0,0 +1,317 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/loop/models/object"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// OpcodeProfile holds how often an opcode ran and the time until the next instruction started.
+type OpcodeProfile struct {
+	OpCode code.OpCode
+	Count  uint64
+	Time   time.Duration
+}
+
+// FunctionProfile holds the statistics of a single function, FunctionId 0 is the main program.
+// Self is the time spent executing the function's own instructions, Total includes the functions it called.
+type FunctionProfile struct {
+	FunctionId   int
+	Calls        uint64
+	Instructions uint64
+	Self         time.Duration
+	Total        time.Duration
+}
+
+// CallSiteProfile holds the statistics of the calls made by a single OpCall instruction to a single callee.
+type CallSiteProfile struct {
+	FunctionId int
+	Ip         int
+	Position   *SourcePosition
+	Callee     string
+	Calls      uint64
+	Time       time.Duration
+}
+
+// Profile is a snapshot of the profiler, every list is sorted with the most expensive entry first.
+type Profile struct {
+	Total     time.Duration
+	Opcodes   []OpcodeProfile
+	Functions []FunctionProfile
+	CallSites []CallSiteProfile
+}
+
+type callSiteKey struct {
+	caller *object.CompiledFunction
+	ip     int
+	callee string
+}
+
+type callSite struct {
+	CallSiteProfile
+	caller *object.CompiledFunction
+	active int
+}
+
+type functionEntry struct {
+	FunctionProfile
+	active int
+}
+
+// activation is stored on a frame while its call is being profiled.
+type activation struct {
+	start time.Time
+	site  *callSite
+}
+
+type profiler struct {
+	ids       map[*object.CompiledFunction]int
+	opcodes   [256]OpcodeProfile
+	functions map[int]*functionEntry
+	callSites map[callSiteKey]*callSite
+
+	total    time.Duration
+	running  bool
+	last     time.Time
+	lastOp   code.OpCode
+	lastFunc *functionEntry
+}
+
+// EnableProfiling makes the VM record opcode, function and call site statistics while it runs, see Profile.
+func (vm *VM) EnableProfiling() {
+	vm.profiler = &profiler{
+		ids:       vm.ids,
+		functions: map[int]*functionEntry{},
+		callSites: map[callSiteKey]*callSite{},
+	}
+
+	vm.profiler.function(0).Calls = 1
+}
+
+// Profile returns the statistics recorded so far, or nil when profiling isn't enabled.
+func (vm *VM) Profile() *Profile {
+	p := vm.profiler
+	if p == nil {
+		return nil
+	}
+
+	profile := &Profile{Total: p.total}
+
+	for i, entry := range p.opcodes {
+		if entry.Count > 0 {
+			entry.OpCode = code.OpCode(i)
+			profile.Opcodes = append(profile.Opcodes, entry)
+		}
+	}
+
+	for _, entry := range p.functions {
+		function := entry.FunctionProfile
+
+		// The main program is active for as long as the VM runs
+		if function.FunctionId == 0 {
+			function.Total = p.total
+		}
+
+		profile.Functions = append(profile.Functions, function)
+	}
+
+	for _, site := range p.callSites {
+		entry := site.CallSiteProfile
+
+		if position, ok := vm.Position(site.caller, site.Ip); ok {
+			entry.Position = &position
+		}
+
+		profile.CallSites = append(profile.CallSites, entry)
+	}
+
+	sort.Slice(profile.Opcodes, func(i, j int) bool {
+		a, b := profile.Opcodes[i], profile.Opcodes[j]
+		if a.Time != b.Time {
+			return a.Time > b.Time
+		}
+
+		return a.OpCode < b.OpCode
+	})
+
+	sort.Slice(profile.Functions, func(i, j int) bool {
+		a, b := profile.Functions[i], profile.Functions[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+
+		return a.FunctionId < b.FunctionId
+	})
+
+	sort.Slice(profile.CallSites, func(i, j int) bool {
+		a, b := profile.CallSites[i], profile.CallSites[j]
+		if a.Time != b.Time {
+			return a.Time > b.Time
+		}
+
+		if a.FunctionId != b.FunctionId {
+			return a.FunctionId < b.FunctionId
+		}
+
+		if a.Ip != b.Ip {
+			return a.Ip < b.Ip
+		}
+
+		return a.Callee < b.Callee
+	})
+
+	return profile
+}
+
+// Fprint writes the profile as a human readable report.
+func (p *Profile) Fprint(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintf(w, "total time: %s\n\n", p.Total)
+
+	fmt.Fprintln(w, "opcode\tcount\ttime\t")
+	for _, entry := range p.Opcodes {
+		fmt.Fprintf(w, "%s\t%d\t%s\t\n", OpcodeName(entry.OpCode), entry.Count, entry.Time)
+	}
+
+	fmt.Fprintln(w, "\nfunction\tcalls\tinstructions\tself\ttotal\t")
+	for _, entry := range p.Functions {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t\n", functionName(entry.FunctionId), entry.Calls, entry.Instructions, entry.Self, entry.Total)
+	}
+
+	fmt.Fprintln(w, "\ncall site\tcallee\tcalls\ttime\t")
+	for _, entry := range p.CallSites {
+		site := fmt.Sprintf("%s (ip=%d)", functionName(entry.FunctionId), entry.Ip)
+		if entry.Position != nil {
+			site += " " + entry.Position.String()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t\n", site, entry.Callee, entry.Calls, entry.Time)
+	}
+
+	w.Flush()
+}
+
+func functionName(id int) string {
+	if id == 0 {
+		return "main"
+	}
+
+	return fmt.Sprintf("function %d", id)
+}
+
+func (p *profiler) function(id int) *functionEntry {
+	entry, ok := p.functions[id]
+	if !ok {
+		entry = &functionEntry{FunctionProfile: FunctionProfile{FunctionId: id}}
+		p.functions[id] = entry
+	}
+
+	return entry
+}
+
+// instruction attributes the time since the previous instruction started to that instruction.
+func (p *profiler) instruction(op code.OpCode, functionId int) {
+	now := time.Now()
+	p.flush(now)
+
+	p.running = true
+	p.last = now
+	p.lastOp = op
+	p.lastFunc = p.function(functionId)
+
+	p.opcodes[op].Count++
+	p.lastFunc.Instructions++
+}
+
+// stop attributes the time of the last instruction, it is called whenever Run returns.
+func (p *profiler) stop() {
+	p.flush(time.Now())
+	p.running = false
+}
+
+func (p *profiler) flush(now time.Time) {
+	if !p.running {
+		return
+	}
+
+	elapsed := now.Sub(p.last)
+
+	p.opcodes[p.lastOp].Time += elapsed
+	p.lastFunc.Self += elapsed
+	p.total += elapsed
+}
+
+// enter records a call made by the OpCall at ip in caller. Recursive calls only count towards the time of their
+// outermost activation, so totals never exceed the time the program ran.
+func (p *profiler) enter(caller *object.CompiledFunction, ip int, callee string) *activation {
+	key := callSiteKey{caller: caller, ip: ip, callee: callee}
+
+	site, ok := p.callSites[key]
+	if !ok {
+		site = &callSite{
+			CallSiteProfile: CallSiteProfile{FunctionId: p.ids[caller], Ip: ip, Callee: callee},
+			caller:          caller,
+		}
+
+		// The main program is represented by a nil function everywhere else
+		if p.ids[caller] == 0 {
+			site.caller = nil
+		}
+
+		p.callSites[key] = site
+	}
+
+	site.Calls++
+	site.active++
+
+	return &activation{start: time.Now(), site: site}
+}
+
+func (p *profiler) leave(a *activation, function *functionEntry) {
+	now := time.Now()
+	elapsed := now.Sub(a.start)
+
+	// The rest of the calling instruction belongs to the caller, otherwise it would count towards Self but not Total
+	p.flush(now)
+	p.last = now
+	p.lastFunc = p.function(a.site.FunctionId)
+
+	a.site.active--
+	if a.site.active == 0 {
+		a.site.Time += elapsed
+	}
+
+	if function != nil {
+		function.active--
+		if function.active == 0 {
+			function.Total += elapsed
+		}
+	}
+}
+
+// enterFunction records the call of a user function and returns its activation.
+func (p *profiler) enterFunction(caller *object.CompiledFunction, ip int, fn *object.CompiledFunction) *activation {
+	function := p.function(p.ids[fn])
+	function.Calls++
+	function.active++
+
+	return p.enter(caller, ip, functionName(p.ids[fn]))
+}
+
+func (p *profiler) leaveFunction(a *activation, fn *object.CompiledFunction) {
+	p.leave(a, p.function(p.ids[fn]))
+}
+
+// builtinLabel names a builtin function in profiles and traces.
+func builtinLabel(fn *object.BuiltinFunction) string {
+	if name, ok := BuiltinName(fn); ok {
+		return name
+	}
+
+	return "builtin"
+}
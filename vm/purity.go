@@ -0,0 +1,579 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"io"
+	"strings"
+)
+
+// PureBuiltins holds the builtin functions without side effects, calls to any other builtin make a function impure.
+var PureBuiltins = map[string]bool{
+	"len": true,
+}
+
+// FunctionPurity is the classification of a single function. Reasons explains why an impure function can't be
+// memoized, a function which only calls impure functions lists those calls.
+type FunctionPurity struct {
+	FunctionId int
+	Constant   int
+	Pure       bool
+	Reasons    []string
+}
+
+// PurityReport holds the classification of every compiled function in the constant pool.
+type PurityReport struct {
+	Functions []FunctionPurity
+
+	byFunction map[*object.CompiledFunction]int
+}
+
+// IsPure reports whether calls to fn can be memoized, functions which weren't analyzed are impure.
+func (r *PurityReport) IsPure(fn *object.CompiledFunction) bool {
+	index, ok := r.byFunction[fn]
+
+	return ok && r.Functions[index].Pure
+}
+
+// Fprint writes the classification of every function, one function per line.
+func (r *PurityReport) Fprint(out io.Writer) {
+	for _, function := range r.Functions {
+		if function.Pure {
+			fmt.Fprintf(out, "function %d (constant %d): pure\n", function.FunctionId, function.Constant)
+			continue
+		}
+
+		fmt.Fprintf(out, "function %d (constant %d): impure, %s\n", function.FunctionId, function.Constant, strings.Join(function.Reasons, ", "))
+	}
+}
+
+// A function is pure when its result only depends on its arguments and calling it changes nothing the rest of the
+// program can observe. Reading variables and globals is allowed when they are assigned exactly once, outside of any
+// loop in the main program, like "var fib = fun(x) { ... }". Variables only used by a single function are private
+// to it, unless the function is recursive as the recursive call overwrites them.
+type purityAnalysis struct {
+	constants []object.Object
+	ids       map[*object.CompiledFunction]int
+	functions map[int]*functionAnalysis // Keyed by constant index, -1 is the main program
+
+	variables map[int]*slotUsage
+	globals   map[int]*slotUsage
+
+	loops  [][2]int // Offsets of the loops in the main program
+	values map[int]abstractValue
+
+	touched map[*slotUsage]bool // Slots used by the code added in the current update
+}
+
+type functionAnalysis struct {
+	constant int
+	fn       *object.CompiledFunction
+	decoded  []instruction
+	reasons  []string
+	callees  map[int]bool
+	writes   bool // Whether the function sets one of its private variables
+	pure     bool
+
+	malformed  bool
+	classified int // The amount of reasons found by classify, the others are added by propagate
+}
+
+// slotUsage records where a variable or global is set and which functions use it.
+type slotUsage struct {
+	stores []slotStore
+	users  map[int]bool
+}
+
+type slotStore struct {
+	function int
+	offset   int
+}
+
+const (
+	unknownValue = iota
+	closureValue
+	builtinValue
+)
+
+// abstractValue is what the analysis knows about a value on the stack, index is a constant or builtin index.
+type abstractValue struct {
+	kind  int
+	index int
+}
+
+// AnalyzePurity classifies every compiled function of the bytecode as pure or impure. Calls are followed
+// transitively, a function is only pure when every function it can call is pure. Calls to functions which can't
+// be determined statically, like parameters, make a function impure.
+func AnalyzePurity(bytecode *compiler.Bytecode) *PurityReport {
+	return NewPurityAnalyzer().Update(bytecode)
+}
+
+// PurityAnalyzer classifies the functions of a program which keeps growing, like the inputs of a REPL. An update only
+// decodes and simulates the instructions and constants added since the previous update, earlier functions are only
+// classified again when they use a variable or global which the added code uses as well.
+type PurityAnalyzer struct {
+	analysis     *purityAnalysis
+	instructions int
+	constants    []int // Indexes of the compiled functions
+}
+
+func NewPurityAnalyzer() *PurityAnalyzer {
+	return &PurityAnalyzer{}
+}
+
+// Update classifies the functions of the bytecode like AnalyzePurity. The bytecode has to continue the bytecode of
+// the previous update, the main program's new instructions have to start with an empty stack.
+func (p *PurityAnalyzer) Update(bytecode *compiler.Bytecode) *PurityReport {
+	a := p.analysis
+	if a == nil || len(bytecode.Instructions) < p.instructions || len(bytecode.Constants) < len(a.constants) {
+		a = &purityAnalysis{
+			ids:       map[*object.CompiledFunction]int{},
+			functions: map[int]*functionAnalysis{},
+			variables: map[int]*slotUsage{},
+			globals:   map[int]*slotUsage{},
+			values:    map[int]abstractValue{},
+		}
+
+		p.analysis = a
+		p.instructions = 0
+		p.constants = nil
+	}
+
+	added := len(a.constants)
+	a.constants = bytecode.Constants
+	a.touched = map[*slotUsage]bool{}
+
+	for i := added; i < len(bytecode.Constants); i++ {
+		if fn, ok := bytecode.Constants[i].(*object.CompiledFunction); ok {
+			// Like FunctionIds, the first constant holding a function decides its id
+			if _, ok := a.ids[fn]; !ok {
+				a.ids[fn] = i + 1
+			}
+
+			a.decode(i, fn, 0)
+			p.constants = append(p.constants, i)
+		}
+	}
+
+	main := a.decode(-1, &object.CompiledFunction{Instructions: bytecode.Instructions[p.instructions:]}, p.instructions)
+	p.instructions = len(bytecode.Instructions)
+
+	a.findLoops(main)
+
+	// The main program runs first, so the closures stored in its variables are known when the functions are analyzed
+	a.simulate(main)
+
+	stale := map[int]bool{}
+	for slot := range a.touched {
+		for user := range slot.users {
+			stale[user] = true
+		}
+	}
+
+	for _, i := range p.constants {
+		if i >= added || stale[i] {
+			a.classify(a.functions[i])
+		}
+	}
+
+	a.propagate(p.constants)
+
+	report := &PurityReport{byFunction: map[*object.CompiledFunction]int{}}
+
+	for _, i := range p.constants {
+		function := a.functions[i]
+
+		report.byFunction[function.fn] = len(report.Functions)
+		report.Functions = append(report.Functions, FunctionPurity{
+			FunctionId: a.ids[function.fn],
+			Constant:   i,
+			Pure:       function.pure,
+			Reasons:    function.reasons,
+		})
+	}
+
+	return report
+}
+
+// decode records the variables and globals used by a function, base is the offset of the instructions in the
+// function. The main program is decoded in parts, one for every update.
+func (a *purityAnalysis) decode(constant int, fn *object.CompiledFunction, base int) *functionAnalysis {
+	decoded, err := decodeInstructions(fn.Instructions)
+
+	for i := range decoded {
+		decoded[i].offset += base
+	}
+
+	function := &functionAnalysis{
+		constant:  constant,
+		fn:        fn,
+		decoded:   decoded,
+		callees:   map[int]bool{},
+		malformed: err != nil,
+	}
+
+	a.functions[constant] = function
+
+	for _, in := range decoded {
+		switch in.op {
+		case code.OpSetVar:
+			slot := a.usage(a.variables, in.operands[0], constant)
+			slot.stores = append(slot.stores, slotStore{function: constant, offset: in.offset})
+		case code.OpGetVar:
+			a.usage(a.variables, in.operands[0], constant)
+		case code.OpSetGlobal:
+			slot := a.usage(a.globals, in.operands[0], constant)
+			slot.stores = append(slot.stores, slotStore{function: constant, offset: in.offset})
+		case code.OpGetGlobal:
+			a.usage(a.globals, in.operands[0], constant)
+		}
+	}
+
+	return function
+}
+
+func (a *purityAnalysis) usage(slots map[int]*slotUsage, index int, function int) *slotUsage {
+	slot, ok := slots[index]
+	if !ok {
+		slot = &slotUsage{users: map[int]bool{}}
+		slots[index] = slot
+	}
+
+	slot.users[function] = true
+	a.touched[slot] = true
+
+	return slot
+}
+
+// findLoops records the ranges of the main program which can run more than once, loops jump backwards.
+func (a *purityAnalysis) findLoops(main *functionAnalysis) {
+	for _, in := range main.decoded {
+		if in.op == code.OpJump && in.operands[0] <= in.offset {
+			a.loops = append(a.loops, [2]int{in.operands[0], in.offset})
+		}
+	}
+}
+
+// stable reports whether a slot only ever holds a single value once it has been set.
+func (a *purityAnalysis) stable(slot *slotUsage) bool {
+	if slot == nil || len(slot.stores) != 1 || slot.stores[0].function != -1 {
+		return false
+	}
+
+	for _, loop := range a.loops {
+		if slot.stores[0].offset >= loop[0] && slot.stores[0].offset <= loop[1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// private reports whether a variable is only used by the given function.
+func (a *purityAnalysis) private(slot *slotUsage, function int) bool {
+	return len(slot.users) == 1 && slot.users[function]
+}
+
+func (a *purityAnalysis) classify(function *functionAnalysis) {
+	reasons := map[string]bool{}
+
+	function.reasons = nil
+	function.callees = map[int]bool{}
+	function.writes = false
+
+	if function.malformed {
+		function.reasons = append(function.reasons, "malformed bytecode")
+	}
+
+	reason := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+
+		if !reasons[message] {
+			reasons[message] = true
+			function.reasons = append(function.reasons, message)
+		}
+	}
+
+	for _, in := range function.decoded {
+		switch in.op {
+		case code.OpSetGlobal:
+			reason("sets global %d", in.operands[0])
+		case code.OpGetGlobal:
+			if !a.stable(a.globals[in.operands[0]]) {
+				reason("reads mutable global %d", in.operands[0])
+			}
+		case code.OpSetVar:
+			if a.private(a.variables[in.operands[0]], function.constant) {
+				function.writes = true
+			} else {
+				reason("sets variable %d", in.operands[0])
+			}
+		case code.OpGetVar:
+			slot := a.variables[in.operands[0]]
+			if !a.stable(slot) && !a.private(slot, function.constant) {
+				reason("reads mutable variable %d", in.operands[0])
+			}
+		case code.OpSetIndex:
+			reason("mutates an array or hashmap")
+		case code.OpSetLocal:
+			// Assigning to a local changes its object in place, which can be the object passed by the caller
+			if in.operands[0] < function.fn.NumParameters {
+				reason("assigns to parameter %d", in.operands[0])
+			} else {
+				reason("assigns to local %d", in.operands[0])
+			}
+		case code.OpGetFree:
+			// Closures of the same function share an id, so their results can't be told apart
+			reason("uses free variables")
+		}
+	}
+
+	for _, call := range a.simulate(function) {
+		switch call.kind {
+		case closureValue:
+			function.callees[call.index] = true
+		case builtinValue:
+			if call.index >= len(object.Builtins) || !PureBuiltins[object.Builtins[call.index].Name] {
+				reason("calls builtin %s", builtinDescription(call.index))
+			}
+		default:
+			reason("calls an unknown function")
+		}
+	}
+
+	function.classified = len(function.reasons)
+}
+
+func builtinDescription(index int) string {
+	if index < len(object.Builtins) {
+		return object.Builtins[index].Name
+	}
+
+	return fmt.Sprintf("%d", index)
+}
+
+// simulate walks every path through a function with an abstract stack and returns the values that are called.
+// For the main program it also records the values stored in stable variables. Paths which join with different
+// values on the stack continue with unknown values.
+func (a *purityAnalysis) simulate(function *functionAnalysis) []abstractValue {
+	decoded := function.decoded
+
+	boundaries := make(map[int]int, len(decoded))
+	for i, in := range decoded {
+		boundaries[in.offset] = i
+	}
+
+	states := make([][]abstractValue, len(decoded))
+	reached := make([]bool, len(decoded))
+
+	var calls []abstractValue
+	called := map[int]abstractValue{}
+
+	type state struct {
+		index int
+		stack []abstractValue
+	}
+
+	work := []state{{index: 0}}
+
+	for len(work) > 0 {
+		current := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if current.index >= len(decoded) {
+			continue
+		}
+
+		if reached[current.index] {
+			merged, changed := mergeStacks(states[current.index], current.stack)
+			if !changed {
+				continue
+			}
+
+			current.stack = merged
+		}
+
+		reached[current.index] = true
+		states[current.index] = current.stack
+
+		in := decoded[current.index]
+		pops, _ := stackEffect(in.op, in.operands)
+
+		if pops > len(current.stack) {
+			if !contains(function.reasons, "malformed bytecode") {
+				function.reasons = append(function.reasons, "malformed bytecode")
+			}
+
+			continue
+		}
+
+		stack := make([]abstractValue, len(current.stack)-pops, len(current.stack)-pops+1)
+		copy(stack, current.stack)
+		popped := current.stack[len(current.stack)-pops:]
+
+		switch in.op {
+		case code.OpClosure:
+			stack = append(stack, abstractValue{kind: closureValue, index: in.operands[0]})
+		case code.OpGetBuiltinFunction:
+			stack = append(stack, abstractValue{kind: builtinValue, index: in.operands[0]})
+		case code.OpGetVar:
+			value := abstractValue{}
+			if a.stable(a.variables[in.operands[0]]) {
+				value = a.values[in.operands[0]]
+			}
+
+			stack = append(stack, value)
+		case code.OpSetVar:
+			if function.constant == -1 && a.stable(a.variables[in.operands[0]]) {
+				a.values[in.operands[0]] = popped[0]
+			}
+		case code.OpCall:
+			callee := popped[0]
+
+			// Every path reaching the call is walked, the callee may differ between them
+			if previous, ok := called[in.offset]; !ok || previous != callee {
+				called[in.offset] = callee
+				calls = append(calls, callee)
+			}
+
+			stack = append(stack, abstractValue{})
+		default:
+			_, pushes := stackEffect(in.op, in.operands)
+			for i := 0; i < pushes; i++ {
+				stack = append(stack, abstractValue{})
+			}
+		}
+
+		next := current.index + 1
+
+		switch in.op {
+		case code.OpJump:
+			work = append(work, state{index: jumpIndex(boundaries, decoded, in.operands[0]), stack: stack})
+		case code.OpJumpIfNotTrue:
+			work = append(work, state{index: jumpIndex(boundaries, decoded, in.operands[0]), stack: stack})
+			work = append(work, state{index: next, stack: stack})
+		case code.OpReturn, code.OpReturnValue:
+			// Like the verifier, the main program keeps running after a top level return
+			if function.constant == -1 {
+				work = append(work, state{index: next, stack: []abstractValue{{}}})
+			}
+		default:
+			work = append(work, state{index: next, stack: stack})
+		}
+	}
+
+	return calls
+}
+
+// mergeStacks combines the stacks of two paths reaching the same instruction, values that differ become unknown.
+func mergeStacks(previous []abstractValue, incoming []abstractValue) ([]abstractValue, bool) {
+	if len(previous) != len(incoming) {
+		return previous, false
+	}
+
+	var merged []abstractValue
+
+	for i := range previous {
+		if previous[i] != incoming[i] && previous[i].kind != unknownValue {
+			if merged == nil {
+				merged = make([]abstractValue, len(previous))
+				copy(merged, previous)
+			}
+
+			merged[i] = abstractValue{}
+		}
+	}
+
+	if merged == nil {
+		return previous, false
+	}
+
+	return merged, true
+}
+
+// propagate marks every function which can reach an impure function, or itself while writing variables, as impure.
+func (a *purityAnalysis) propagate(constants []int) {
+	for _, i := range constants {
+		function := a.functions[i]
+		function.reasons = function.reasons[:function.classified]
+		function.pure = len(function.reasons) == 0
+
+		if function.writes && a.reaches(i, i) {
+			function.pure = false
+			function.reasons = append(function.reasons, "sets variables while being recursive")
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, i := range constants {
+			function := a.functions[i]
+			if !function.pure {
+				continue
+			}
+
+			for callee := range function.callees {
+				target, ok := a.functions[callee]
+				if ok && target.pure {
+					continue
+				}
+
+				function.pure = false
+				function.reasons = append(function.reasons, fmt.Sprintf("calls impure function %d", a.calleeId(callee)))
+				changed = true
+
+				break
+			}
+		}
+	}
+}
+
+// reaches reports whether function from can call function to, directly or through other functions.
+func (a *purityAnalysis) reaches(from int, to int) bool {
+	visited := map[int]bool{}
+	work := []int{from}
+
+	for len(work) > 0 {
+		current := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		function, ok := a.functions[current]
+		if !ok {
+			continue
+		}
+
+		for callee := range function.callees {
+			if callee == to {
+				return true
+			}
+
+			if !visited[callee] {
+				visited[callee] = true
+				work = append(work, callee)
+			}
+		}
+	}
+
+	return false
+}
+
+func (a *purityAnalysis) calleeId(constant int) int {
+	if function, ok := a.functions[constant]; ok {
+		return a.ids[function.fn]
+	}
+
+	return constant + 1
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
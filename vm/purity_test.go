@@ -0,0 +1,173 @@
+package vm
+
+import (
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzePurity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"var double = fun(x) { return x * 2 }; double(1)", []string{"pure"}},
+		{"var fib = fun(x) { if (x < 2) { return x }; return fib(x - 1) + fib(x - 2) }; fib(10)", []string{"pure"}},
+		{"var size = fun(x) { return len(x) }", []string{"pure"}},
+		{"var f = fun(x) { var y = x * 2; return y }", []string{"pure"}},
+		{"var adder = fun(x) { return fun(y) { return x + y } }", []string{"uses free variables", "pure"}},
+		{"var count = 0; var inc = fun() { count = count + 1; return count }", []string{"reads mutable variable 0, sets variable 0"}},
+		{"var f = fun(a) { a[0] = 1; return a }", []string{"mutates an array or hashmap"}},
+		{"var f = fun(x) { x = 2; return x }", []string{"assigns to parameter 0"}},
+		{"var g = fun(a) { a[0] = 1 }; var f = fun(x) { return g([x]) }", []string{"mutates an array or hashmap", "calls impure function 3"}},
+		{"var apply = fun(f, x) { return f(x) }", []string{"calls an unknown function"}},
+		{"var f = fun(x) { var y = x; if (x > 0) { f(x - 1) }; return y }", []string{"sets variables while being recursive"}},
+		// g is set twice, so f can't know which function it calls
+		{"var g = fun() { return 1 }; var f = fun() { return g() }; g = fun() { return 2 }", []string{"pure", "reads mutable variable 0, calls an unknown function", "pure"}},
+		{"var i = 0; var f = fun() { return 1 }; while (i < 2) { var x = fun() { return f() }; i = i + 1 }", []string{"pure", "pure"}},
+		// f is set once, but on every iteration of the loop
+		{"var i = 0; while (i < 2) { var f = fun() { return 1 }; var g = fun() { return f() }; i = i + 1 }", []string{"pure", "reads mutable variable 1, calls an unknown function"}},
+	}
+
+	for _, tt := range tests {
+		report := analyze(t, tt.input)
+
+		var got []string
+		for _, function := range report.Functions {
+			if function.Pure {
+				got = append(got, "pure")
+			} else {
+				got = append(got, strings.Join(function.Reasons, ", "))
+			}
+		}
+
+		if strings.Join(got, "; ") != strings.Join(tt.expected, "; ") {
+			t.Errorf("wrong classification for %q. want=%q. got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestPurityAnalyzer(t *testing.T) {
+	tests := [][]string{
+		{"var double = fun(x) { return x * 2 }", "var f = fun(x) { return double(x) + 1 }", "f(2)"},
+		// Setting g again makes f impure, although f was added before
+		{"var g = fun() { return 1 }", "var f = fun() { return g() }", "g = fun() { return 2 }"},
+		{"var count = 0", "var inc = fun() { return count + 1 }", "count = 1"},
+		{"var f = fun(x) { var y = x; return y }", "var g = fun(x) { if (x > 0) { f(x - 1) }; return x }"},
+		{"var i = 0", "while (i < 2) { var f = fun() { return 1 }; var g = fun() { return f() }; i = i + 1 }", "var h = fun() { return i }"},
+	}
+
+	for _, inputs := range tests {
+		analyzer := NewPurityAnalyzer()
+		comp := compiler.Create()
+
+		for i, input := range inputs {
+			err := comp.Compile(parse(input), "", "", "")
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			var incremental, full strings.Builder
+			analyzer.Update(comp.Bytecode()).Fprint(&incremental)
+			AnalyzePurity(comp.Bytecode()).Fprint(&full)
+
+			if incremental.String() != full.String() {
+				t.Errorf("wrong classification after %q. want=%q. got=%q", strings.Join(inputs[:i+1], "; "), full.String(), incremental.String())
+			}
+		}
+	}
+}
+
+func TestAnalyzePurity_Builtins(t *testing.T) {
+	delete(PureBuiltins, "len")
+	defer func() { PureBuiltins["len"] = true }()
+
+	report := analyze(t, "var size = fun(x) { return len(x) }; var f = fun(x) { return size(x) }")
+
+	if report.Functions[0].Pure || report.Functions[0].Reasons[0] != "calls builtin len" {
+		t.Errorf("expected impure builtin call. got=%+v", report.Functions[0])
+	}
+
+	if report.Functions[1].Pure {
+		t.Errorf("expected caller of impure function to be impure. got=%+v", report.Functions[1])
+	}
+
+	var out strings.Builder
+	report.Fprint(&out)
+
+	expected := "function 1 (constant 0): impure, calls builtin len\nfunction 2 (constant 1): impure, calls impure function 1\n"
+	if out.String() != expected {
+		t.Errorf("wrong report. want=%q. got=%q", expected, out.String())
+	}
+}
+
+func TestAnalyzePurity_Locals(t *testing.T) {
+	// The compiler stores the variables of a function with OpSetVar, only parameters are assigned with OpSetLocal
+	fn := &object.CompiledFunction{
+		Instructions: concatInstructions(
+			code.Make(code.OpNull),
+			code.Make(code.OpSetLocal, 1),
+			code.Make(code.OpNull),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpNull),
+			code.Make(code.OpReturnValue),
+		),
+		NumLocals:     2,
+		NumParameters: 1,
+	}
+
+	report := AnalyzePurity(&compiler.Bytecode{
+		Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+		Constants:    []object.Object{fn},
+	})
+
+	reasons := strings.Join(report.Functions[0].Reasons, ", ")
+	if reasons != "assigns to local 1, assigns to parameter 0" {
+		t.Errorf("wrong reasons. got=%q", reasons)
+	}
+}
+
+func TestVM_MemoizesPureFunctionsOnly(t *testing.T) {
+	program := parse("var count = 0; var inc = fun(x) { count = count + x; return count }; var double = fun(x) { return x * 2 }; inc(1); inc(1); double(2) + double(2) + inc(1)")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+	vm.EnableMemoization()
+
+	err = vm.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, 11, vm.LastPoppedStackElem())
+
+	if vm.MemoCache().Len() != 1 || vm.MemoStats().Hits != 1 {
+		t.Errorf("expected only double to be memoized. entries=%d. stats=%+v", vm.MemoCache().Len(), vm.MemoStats())
+	}
+}
+
+func analyze(t *testing.T, input string) *PurityReport {
+	t.Helper()
+
+	program := parse(input)
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+
+	err = Verify(bytecode)
+	if err != nil {
+		t.Fatalf("verify error: %s", err)
+	}
+
+	return AnalyzePurity(bytecode)
+}
@@ -1,7 +1,7 @@
 package vm
 
 import (
-	"fmt"
+	"context"
 	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/loop/models/object"
 )
@@ -9,6 +9,62 @@ import (
 type RanOpcode func(opCode code.OpCode)
 
 func (vm *VM) Run(calledOpcode RanOpcode) error {
+	return vm.RunContext(context.Background(), calledOpcode)
+}
+
+// RunContext runs the bytecode like Run, but stops with ErrCancelled or ErrDeadlineExceeded once ctx is done.
+// The context is checked on backward jumps and calls, so the VM is left as it was at that instruction.
+// Every returned error is a *RuntimeError.
+func (vm *VM) RunContext(ctx context.Context, calledOpcode RanOpcode) (err error) {
+	// Malformed bytecode must never take down the host, any panic left in the dispatch loop becomes an error. A panic
+	// of the host's own hooks is passed on, it isn't caused by the bytecode.
+	defer func() {
+		if r := recover(); r != nil {
+			if vm.inHook {
+				vm.inHook = false
+				panic(r)
+			}
+
+			err = vm.runtimeError(&PanicError{Value: r})
+		}
+	}()
+
+	if vm.profiler != nil {
+		defer vm.profiler.stop()
+	}
+
+	if vm.sampler != nil {
+		vm.sampler.resume()
+	}
+
+	err = vm.run(ctx, calledOpcode)
+	if err != nil {
+		return vm.runtimeError(err)
+	}
+
+	return nil
+}
+
+// callHooks calls the instruction hook and the RanOpcode callback of the host.
+func (vm *VM) callHooks(op code.OpCode, calledOpcode RanOpcode) error {
+	vm.inHook = true
+
+	var err error
+	if vm.hook != nil {
+		err = vm.hook(op)
+	}
+
+	if err == nil && calledOpcode != nil {
+		calledOpcode(op)
+	}
+
+	// Not deferred, RunContext checks it when a hook panics
+	vm.inHook = false
+
+	return err
+}
+
+func (vm *VM) run(ctx context.Context, calledOpcode RanOpcode) error {
 	var ip int                // Instruction Pointer
 	var ins code.Instructions // Current instructions
 	var op code.OpCode        // Current opcode
@@ -20,8 +76,31 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 		ins = vm.currentFrame().Instructions()
 		op = code.OpCode(ins[ip])
 
-		if calledOpcode != nil {
-			calledOpcode(op)
+		vm.lastIp = ip
+		vm.lastOp = op
+
+		if vm.meterFuel {
+			err := vm.consumeFuel(op)
+			if err != nil {
+				return err
+			}
+		}
+
+		vm.executed++
+
+		if vm.profiler != nil {
+			vm.profiler.instruction(op, vm.FunctionId(vm.currentFrame().closure.Fn))
+		}
+
+		if vm.sampler != nil {
+			vm.sample(ip)
+		}
+
+		if vm.hook != nil || calledOpcode != nil {
+			err := vm.callHooks(op, calledOpcode)
+			if err != nil {
+				return err
+			}
 		}
 
 		switch op {
@@ -29,6 +108,10 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			constIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
+			if int(constIndex) >= len(vm.constants) {
+				return &OutOfRangeError{Kind: "constant", Index: int(constIndex), Length: len(vm.constants)}
+			}
+
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
@@ -38,33 +121,11 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			if err != nil {
 				return err
 			}
-		case code.OpMultiply:
-			right := vm.pop()
-			left := vm.pop()
-			leftValue := left.(*object.Integer).Value
-			rightValue := right.(*object.Integer).Value
-
-			result := leftValue * rightValue
-
-			vm.push(&object.Integer{Value: result})
-		case code.OpDivide:
-			right := vm.pop()
-			left := vm.pop()
-			leftValue := left.(*object.Integer).Value
-			rightValue := right.(*object.Integer).Value
-
-			result := leftValue / rightValue
-
-			vm.push(&object.Integer{Value: result})
-		case code.OpSubtract:
-			right := vm.pop()
-			left := vm.pop()
-			leftValue := left.(*object.Integer).Value
-			rightValue := right.(*object.Integer).Value
-
-			result := leftValue - rightValue
-
-			vm.push(&object.Integer{Value: result})
+		case code.OpMultiply, code.OpDivide, code.OpSubtract, OpModulo, OpPower:
+			err := vm.executeArithmetic(op)
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
 		case code.OpTrue:
@@ -78,6 +139,14 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			}
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
+
+			if pos <= ip {
+				err := checkContext(ctx)
+				if err != nil {
+					return err
+				}
+			}
+
 			vm.currentFrame().ip = pos - 1
 		case code.OpJumpIfNotTrue:
 			pos := int(code.ReadUint16(ins[ip+1:]))
@@ -86,11 +155,17 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			condition := vm.pop()
 			obj, ok := condition.(*object.Boolean)
 			if !ok {
-				return fmt.Errorf("condition is not boolean. got=%q", condition.Type())
+				return &TypeError{OpCode: code.OpJumpIfNotTrue, Types: []string{condition.Type()}}
 			}
 
 			if !obj.Value {
 				vm.currentFrame().ip = pos - 1
+
+				// The OpPop after a while loop which never ran has no value to pop
+				frame := vm.currentFrame()
+				if pos < len(ins) && code.OpCode(ins[pos]) == code.OpPop && vm.sp == frame.basePointer+frame.closure.Fn.NumLocals {
+					frame.ip = pos
+				}
 			}
 		case code.OpNull:
 			err := vm.push(Null)
@@ -106,6 +181,10 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
+			if vm.globals[globalIndex] == nil {
+				return &UndefinedGlobalError{Index: int(globalIndex)}
+			}
+
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
@@ -145,10 +224,15 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 				return err
 			}
 		case code.OpCall:
+			err := checkContext(ctx)
+			if err != nil {
+				return err
+			}
+
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
-			err := vm.callFunction(int(numArgs))
+			err = vm.callFunction(int(numArgs))
 			if err != nil {
 				return err
 			}
@@ -196,13 +280,29 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 
 			frame := vm.currentFrame()
 
+			if int(localIndex) >= frame.closure.Fn.NumLocals {
+				return &OutOfRangeError{Kind: "local", Index: int(localIndex), Length: frame.closure.Fn.NumLocals}
+			}
+
 			pop := vm.pop()
 
 			stackItem := vm.stack[frame.basePointer+int(localIndex)]
 
+			if stackItem == nil {
+				vm.stack[frame.basePointer+int(localIndex)] = pop
+				continue
+			}
+
+			// Overflowing arithmetic in IntegerPromote mode turns an integer into a big integer and back, either one replaces
+			// the other
+			if pop.Type() != stackItem.Type() && isInteger(pop) && isInteger(stackItem) {
+				vm.stack[frame.basePointer+int(localIndex)] = pop
+				continue
+			}
+
 			// TODO: To allow setting we can't directly do this, instead we have to go through each possible type and *set* the value. This needs improvement
 			if pop.Type() != stackItem.Type() {
-				return fmt.Errorf("unable to assign different type. got=%q. expected=%q", pop.Type(), stackItem.Type())
+				return &TypeError{OpCode: code.OpSetLocal, Types: []string{stackItem.Type(), pop.Type()}}
 			}
 
 			switch obj := stackItem.(type) {
@@ -216,8 +316,12 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 				obj.Pairs = pop.(*object.HashMap).Pairs
 			case *object.Boolean:
 				obj.Value = pop.(*object.Boolean).Value
+			case *BigInteger:
+				obj.Value = pop.(*BigInteger).Value
+			case *Float:
+				obj.Value = pop.(*Float).Value
 			default:
-				return fmt.Errorf("unable to assign to %q", stackItem.Type())
+				return &TypeError{OpCode: code.OpSetLocal, Types: []string{stackItem.Type()}}
 			}
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
@@ -225,6 +329,10 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 
 			frame := vm.currentFrame()
 
+			if int(localIndex) >= frame.closure.Fn.NumLocals {
+				return &OutOfRangeError{Kind: "local", Index: int(localIndex), Length: frame.closure.Fn.NumLocals}
+			}
+
 			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
 			if err != nil {
 				return err
@@ -233,6 +341,10 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
+			if int(builtinIndex) >= len(object.Builtins) {
+				return &OutOfRangeError{Kind: "builtin function", Index: int(builtinIndex), Length: len(object.Builtins)}
+			}
+
 			definition := object.Builtins[builtinIndex]
 
 			err := vm.push(definition.Builtin)
@@ -253,30 +365,39 @@ func (vm *VM) Run(calledOpcode RanOpcode) error {
 			vm.currentFrame().ip += 1
 
 			currentClosure := vm.currentFrame().closure
+			if int(freeIndex) >= len(currentClosure.Free) {
+				return &OutOfRangeError{Kind: "free variable", Index: int(freeIndex), Length: len(currentClosure.Free)}
+			}
+
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpSetIndex:
 			arrObj := vm.pop()
+			indexObj := vm.pop()
 
 			if arrObj.Type() != "ARRAY" && arrObj.Type() != "HASHMAP" {
-				return fmt.Errorf("not indexable element. got=%q", arrObj.Type())
+				return &TypeError{OpCode: code.OpSetIndex, Types: []string{arrObj.Type(), indexObj.Type()}}
 			}
 
-			indexObj := vm.pop()
-
 			if arrObj.Type() == "ARRAY" {
 				if indexObj.Type() != "INTEGER" {
-					return fmt.Errorf("(array) unable to use as index. got=%q", indexObj.Type())
+					return &TypeError{OpCode: code.OpSetIndex, Types: []string{arrObj.Type(), indexObj.Type()}}
 				}
 
 				valueObj := vm.pop()
+				elements := arrObj.(*object.Array).Elements
+				index := indexObj.(*object.Integer).Value
+
+				if index < 0 || index >= int64(len(elements)) {
+					return &IndexError{Index: index, Length: len(elements)}
+				}
 
-				arrObj.(*object.Array).Elements[indexObj.(*object.Integer).Value] = valueObj
+				elements[index] = valueObj
 			} else if arrObj.Type() == "HASHMAP" {
 				if _, ok := indexObj.(object.Hashable); !ok {
-					return fmt.Errorf("(hashmap) unable to use as index. got=%q", indexObj.Type())
+					return &TypeError{OpCode: code.OpSetIndex, Types: []string{arrObj.Type(), indexObj.Type()}}
 				}
 
 				valueObj := vm.pop()
@@ -0,0 +1,96 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/looplanguage/loop/models/object"
+	"io"
+	"sort"
+)
+
+type SourcePosition struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func (p SourcePosition) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// LineEntry maps the instruction at Offset, and every instruction up to the next entry, to a source position.
+type LineEntry struct {
+	Offset int `json:"offset"`
+	SourcePosition
+}
+
+// SourceMap holds the line tables of a program. Functions is keyed by the constant index of the compiled function.
+type SourceMap struct {
+	Main      []LineEntry         `json:"main"`
+	Functions map[int][]LineEntry `json:"functions"`
+}
+
+func LoadSourceMap(r io.Reader) (*SourceMap, error) {
+	var sourceMap SourceMap
+
+	err := json.NewDecoder(r).Decode(&sourceMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source map: %w", err)
+	}
+
+	return &sourceMap, nil
+}
+
+// SetSourceMap attaches line tables to the functions of this VM, positions are then included in errors and backtraces.
+func (vm *VM) SetSourceMap(sourceMap *SourceMap) {
+	vm.lineTables = make(map[*object.CompiledFunction][]LineEntry)
+
+	if sourceMap == nil {
+		return
+	}
+
+	vm.lineTables[vm.frames[0].closure.Fn] = sortedEntries(sourceMap.Main)
+
+	for index, entries := range sourceMap.Functions {
+		if index < 0 || index >= len(vm.constants) {
+			continue
+		}
+
+		if fn, ok := vm.constants[index].(*object.CompiledFunction); ok {
+			vm.lineTables[fn] = sortedEntries(entries)
+		}
+	}
+}
+
+// Position resolves an instruction offset in fn to its source position, fn is nil for the main program.
+func (vm *VM) Position(fn *object.CompiledFunction, ip int) (SourcePosition, bool) {
+	if fn == nil {
+		fn = vm.frames[0].closure.Fn
+	}
+
+	entries, ok := vm.lineTables[fn]
+	if !ok || len(entries) == 0 {
+		return SourcePosition{}, false
+	}
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Offset > ip
+	})
+
+	if i == 0 {
+		return SourcePosition{}, false
+	}
+
+	return entries[i-1].SourcePosition, true
+}
+
+func sortedEntries(entries []LineEntry) []LineEntry {
+	sorted := make([]LineEntry, len(entries))
+	copy(sorted, entries)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+
+	return sorted
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"encoding/json"
+	"github.com/looplanguage/loop/models/object"
+	"io"
+	"time"
+)
+
+// TraceEvent is a single event of the Chrome Trace Event format, Timestamp is in microseconds since the trace started.
+type TraceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat"`
+	Phase     string                 `json:"ph"`
+	Timestamp float64                `json:"ts"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// Trace holds the function calls recorded between StartTrace and StopTrace as begin and end events. Calls which
+// returned a memoized result are instant events.
+type Trace struct {
+	Events []TraceEvent
+}
+
+type tracer struct {
+	start  time.Time
+	sample uint64
+	calls  uint64
+	trace  *Trace
+}
+
+// StartTrace records the entry and exit of every sample-th function call, a sample of 0 or 1 records all calls.
+// Calls which aren't sampled leave out their own events only, the calls they make are sampled independently.
+func (vm *VM) StartTrace(sample uint64) {
+	if vm.tracer != nil {
+		return
+	}
+
+	if sample == 0 {
+		sample = 1
+	}
+
+	vm.tracer = &tracer{start: time.Now(), sample: sample, trace: &Trace{}}
+}
+
+// StopTrace stops recording and returns the trace, or nil when no trace was started. Calls which are still active,
+// e.g. because Run returned an error, are ended so every begin event has a matching end event.
+func (vm *VM) StopTrace() *Trace {
+	t := vm.tracer
+	if t == nil {
+		return nil
+	}
+
+	for i := vm.frameIndex - 1; i > 0; i-- {
+		frame := vm.frames[i]
+
+		if frame.traced {
+			t.end(functionName(vm.FunctionId(frame.closure.Fn)), "function")
+			frame.traced = false
+		}
+	}
+
+	vm.tracer = nil
+
+	return t.trace
+}
+
+// WriteJSON writes the trace as a JSON object which can be opened in chrome://tracing or Perfetto.
+func (t *Trace) WriteJSON(w io.Writer) error {
+	events := t.Events
+	if events == nil {
+		events = []TraceEvent{}
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ns",
+	})
+}
+
+// beginTrace records the entry of a call made by the current OpCall, it returns false when the call isn't sampled.
+func (vm *VM) beginTrace(name string, category string) bool {
+	return vm.traceCall(name, category, "B", nil)
+}
+
+// traceMemoized records a call made by the current OpCall which returned a memoized result. The function didn't run,
+// so the call is an instant event without a duration.
+func (vm *VM) traceMemoized(name string) {
+	vm.traceCall(name, "function", "i", map[string]interface{}{"memoized": true})
+}
+
+// traceCall records an event for a call made by the current OpCall, it returns false when the call isn't sampled.
+func (vm *VM) traceCall(name string, category string, phase string, extra map[string]interface{}) bool {
+	t := vm.tracer
+
+	t.calls++
+	if (t.calls-1)%t.sample != 0 {
+		return false
+	}
+
+	var caller *object.CompiledFunction
+	if vm.frameIndex > 1 {
+		caller = vm.currentFrame().closure.Fn
+	}
+
+	args := map[string]interface{}{
+		"caller": functionName(vm.FunctionId(vm.currentFrame().closure.Fn)),
+		"ip":     vm.lastIp,
+	}
+
+	if position, ok := vm.Position(caller, vm.lastIp); ok {
+		args["position"] = position.String()
+	}
+
+	for key, value := range extra {
+		args[key] = value
+	}
+
+	t.event(name, category, phase, args)
+
+	return true
+}
+
+func (t *tracer) end(name string, category string) {
+	t.event(name, category, "E", nil)
+}
+
+func (t *tracer) event(name string, category string, phase string, args map[string]interface{}) {
+	t.trace.Events = append(t.trace.Events, TraceEvent{
+		Name:      name,
+		Category:  category,
+		Phase:     phase,
+		Timestamp: float64(time.Since(t.start).Nanoseconds()) / 1000,
+		Pid:       1,
+		Tid:       1,
+		Args:      args,
+	})
+}
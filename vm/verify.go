@@ -0,0 +1,276 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+)
+
+// VerifyError describes invalid bytecode. Function is the constant index of the function, or -1 for the main program.
+type VerifyError struct {
+	Function int
+	Offset   int
+	Message  string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Function < 0 {
+		return fmt.Sprintf("invalid bytecode in main at offset %d: %s", e.Offset, e.Message)
+	}
+
+	return fmt.Sprintf("invalid bytecode in function constant %d at offset %d: %s", e.Function, e.Offset, e.Message)
+}
+
+type verifier struct {
+	constants []object.Object
+	decoded   map[int][]instruction
+	numFree   map[int]int
+}
+
+// Verify checks bytecode before it is executed, so a corrupt or hostile file can't make the VM read or jump outside
+// of its instructions, constants or locals. Globals need no check, their operands are 16 bits wide and GlobalsSize
+// holds every index which fits in them.
+func Verify(bytecode *compiler.Bytecode) error {
+	v := &verifier{
+		constants: bytecode.Constants,
+		decoded:   map[int][]instruction{},
+		numFree:   map[int]int{},
+	}
+
+	var functions []int
+	for i, constant := range bytecode.Constants {
+		if _, ok := constant.(*object.CompiledFunction); ok {
+			functions = append(functions, i)
+		}
+	}
+
+	// Decode everything first, the amount of free variables of a function is only known from its OpClosure sites
+	err := v.decode(-1, bytecode.Instructions)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range functions {
+		err = v.decode(i, v.constants[i].(*object.CompiledFunction).Instructions)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = v.verifyFunction(-1, bytecode.Instructions, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range functions {
+		fn := v.constants[i].(*object.CompiledFunction)
+
+		err = v.verifyFunction(i, fn.Instructions, fn.NumLocals)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) decode(function int, ins code.Instructions) error {
+	decoded, err := decodeInstructions(ins)
+	if err != nil {
+		err.Function = function
+		return err
+	}
+
+	v.decoded[function] = decoded
+
+	for _, in := range decoded {
+		if in.op != code.OpClosure {
+			continue
+		}
+
+		constIndex, numFree := in.operands[0], in.operands[1]
+		if constIndex >= len(v.constants) {
+			return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf("constant %d out of range", constIndex)}
+		}
+
+		if _, ok := v.constants[constIndex].(*object.CompiledFunction); !ok {
+			return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf("constant %d is not a function", constIndex)}
+		}
+
+		if previous, ok := v.numFree[constIndex]; ok && previous != numFree {
+			return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf("function constant %d closed over %d and %d free variables", constIndex, previous, numFree)}
+		}
+
+		v.numFree[constIndex] = numFree
+	}
+
+	return nil
+}
+
+func (v *verifier) verifyFunction(function int, ins code.Instructions, numLocals int) error {
+	decoded := v.decoded[function]
+	isMain := function < 0
+
+	boundaries := map[int]int{}
+	for i, in := range decoded {
+		boundaries[in.offset] = i
+	}
+
+	fail := func(in instruction, format string, a ...interface{}) error {
+		return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf(format, a...)}
+	}
+
+	for _, in := range decoded {
+		switch in.op {
+		case code.OpConstant:
+			if in.operands[0] >= len(v.constants) {
+				return fail(in, "constant %d out of range", in.operands[0])
+			}
+		case code.OpJump, code.OpJumpIfNotTrue:
+			target := in.operands[0]
+			if _, ok := boundaries[target]; !ok && target != len(ins) {
+				return fail(in, "jump target %d is not an instruction boundary", target)
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			if in.operands[0] >= numLocals {
+				return fail(in, "local %d out of range, function has %d locals", in.operands[0], numLocals)
+			}
+		case code.OpGetBuiltinFunction:
+			if in.operands[0] >= len(object.Builtins) {
+				return fail(in, "unknown builtin function %d", in.operands[0])
+			}
+		case code.OpGetFree:
+			if isMain || in.operands[0] >= v.numFree[function] {
+				return fail(in, "free variable %d out of range", in.operands[0])
+			}
+		case code.OpHash:
+			if in.operands[0]%2 != 0 {
+				return fail(in, "odd amount of hashmap elements %d", in.operands[0])
+			}
+		case code.OpReturn:
+			if isMain {
+				return fail(in, "return outside of a function")
+			}
+		}
+	}
+
+	return v.verifyStack(function, decoded, boundaries, numLocals)
+}
+
+// verifyStack walks every reachable path through a function. Underflows are checked against the shallowest stack an
+// instruction is reached with and StackSize against the deepest. The compiler leaves a value behind for every
+// iteration of a while loop and pops one after the loop. A loop which never ran leaves nothing to pop, so the VM skips
+// an OpPop which OpJumpIfNotTrue jumps to with an empty stack and so does the verifier. Deeper paths are followed at
+// most twice per instruction, loops that keep growing the stack are bounded by the runtime check in push.
+func (v *verifier) verifyStack(function int, decoded []instruction, boundaries map[int]int, numLocals int) error {
+	isMain := function < 0
+
+	if len(decoded) == 0 {
+		if isMain {
+			return nil
+		}
+
+		return &VerifyError{Function: function, Message: "function has no instructions"}
+	}
+
+	minDepths := make([]int, len(decoded))
+	maxDepths := make([]int, len(decoded))
+	deeper := make([]int, len(decoded))
+	visited := make([]bool, len(decoded))
+
+	type state struct {
+		index int
+		depth int
+	}
+
+	work := []state{{index: 0, depth: numLocals}}
+
+	for len(work) > 0 {
+		current := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if current.index >= len(decoded) {
+			if !isMain {
+				return &VerifyError{Function: function, Offset: decoded[len(decoded)-1].offset, Message: "function does not end with a return"}
+			}
+
+			continue
+		}
+
+		i := current.index
+
+		switch {
+		case !visited[i]:
+			visited[i] = true
+			minDepths[i], maxDepths[i] = current.depth, current.depth
+		case current.depth < minDepths[i]:
+			minDepths[i] = current.depth
+		case current.depth > maxDepths[i] && deeper[i] < 2:
+			deeper[i]++
+			maxDepths[i] = current.depth
+		default:
+			continue
+		}
+
+		in := decoded[i]
+		pops, pushes := stackEffect(in.op, in.operands)
+
+		// Underflows are reported once every path has been followed
+		depth := current.depth - pops
+		if depth < numLocals {
+			depth = numLocals
+		}
+
+		depth += pushes
+
+		next := i + 1
+
+		switch in.op {
+		case code.OpJump:
+			work = append(work, state{index: jumpIndex(boundaries, decoded, in.operands[0]), depth: depth})
+		case code.OpJumpIfNotTrue:
+			target := jumpIndex(boundaries, decoded, in.operands[0])
+			if target < len(decoded) && decoded[target].op == code.OpPop && depth == numLocals {
+				target++
+			}
+
+			work = append(work, state{index: target, depth: depth})
+			work = append(work, state{index: next, depth: depth})
+		case code.OpReturn:
+		case code.OpReturnValue:
+			// The main program keeps running after a top level return, with only the returned value on the stack
+			if isMain {
+				work = append(work, state{index: next, depth: 1})
+			}
+		default:
+			work = append(work, state{index: next, depth: depth})
+		}
+	}
+
+	for i, in := range decoded {
+		if !visited[i] {
+			continue
+		}
+
+		pops, pushes := stackEffect(in.op, in.operands)
+
+		if minDepths[i]-pops < numLocals {
+			return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf("%s pops %d values from a stack of %d", OpcodeName(in.op), pops, minDepths[i]-numLocals)}
+		}
+
+		if depth := maxDepths[i] - pops + pushes; depth > StackSize {
+			return &VerifyError{Function: function, Offset: in.offset, Message: fmt.Sprintf("stack depth %d exceeds %d", depth, StackSize)}
+		}
+	}
+
+	return nil
+}
+
+func jumpIndex(boundaries map[int]int, decoded []instruction, target int) int {
+	if index, ok := boundaries[target]; ok {
+		return index
+	}
+
+	return len(decoded)
+}
@@ -0,0 +1,124 @@
+package vm
+
+import (
+	"errors"
+	"github.com/looplanguage/compiler/code"
+	"github.com/looplanguage/compiler/compiler"
+	"github.com/looplanguage/loop/models/object"
+	"testing"
+)
+
+func TestVerify_CompiledPrograms(t *testing.T) {
+	tests := []string{
+		"1 + 2",
+		"if (1 > 10) { return 10 } else if(true) { return 400 } else { return 20 }",
+		"if(true) { if(true) { return 10; } return 50; return 20 }",
+		"var one = 1; one",
+		"{1 + 1: 2 + 2, 3: 4 * 2}[2]",
+		"[1, 2, 3][1]",
+		`len([1, 2, 3])`,
+		"var double = fun(x) { return x * 2 }; var test = fun() { return double(2) + double(2) }; test()",
+		"fun() { }()",
+		"var i = 0; while (i < 3) { i = i + 1 }; i",
+		"var i = 5; while (i < 3) { i = i + 1 }; i",
+		`
+		var newAdderOuter = fun(a, b) {
+			var c = a + b;
+			return fun(d) {
+				var e = d + c;
+				return fun(f) { return e + f; };
+			};
+		};
+		newAdderOuter(1, 2)(3)(8);
+		`,
+		`
+		var fibonacci = fun(x) {
+			return if (x == 0) {
+				return 0;
+			} else {
+				if (x == 1) {
+					return 1;
+				} else {
+					return fibonacci(x - 1) + fibonacci(x - 2);
+				}
+			}
+		};
+		fibonacci(15);
+		`,
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Errorf("valid program %q rejected: %s", input, err)
+		}
+	}
+}
+
+func TestVerify_InvalidBytecode(t *testing.T) {
+	function := func(numLocals int, instructions ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(instructions...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		name         string
+		instructions code.Instructions
+		constants    []object.Object
+	}{
+		{"unknown opcode", code.Instructions{255}, nil},
+		{"truncated operands", code.Make(code.OpConstant, 0)[:2], []object.Object{&object.Integer{Value: 1}}},
+		{"constant out of range", code.Make(code.OpConstant, 1), []object.Object{&object.Integer{Value: 1}}},
+		{"jump into operand", concatInstructions(code.Make(code.OpJump, 1)), nil},
+		{"local in main", concatInstructions(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop)), nil},
+		{"free variable in main", concatInstructions(code.Make(code.OpGetFree, 0), code.Make(code.OpPop)), nil},
+		{"unknown builtin", concatInstructions(code.Make(code.OpGetBuiltinFunction, 255), code.Make(code.OpPop)), nil},
+		{"stack underflow", code.Make(code.OpPop), nil},
+		{"stack underflow on one branch", concatInstructions(
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpIfNotTrue, 7),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpAdd),
+			code.Make(code.OpPop),
+		), []object.Object{&object.Integer{Value: 1}}},
+		{"closure over non-function", code.Make(code.OpClosure, 0, 0), []object.Object{&object.Integer{Value: 1}}},
+		{"local out of range", code.Make(code.OpClosure, 0, 0), []object.Object{
+			function(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)),
+		}},
+		{"free variable out of range", code.Make(code.OpClosure, 0, 0), []object.Object{
+			function(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+		}},
+		{"missing return", code.Make(code.OpClosure, 0, 0), []object.Object{
+			function(0, code.Make(code.OpNull), code.Make(code.OpPop)),
+		}},
+		{"return value underflow", code.Make(code.OpClosure, 0, 0), []object.Object{
+			function(1, code.Make(code.OpReturnValue)),
+		}},
+	}
+
+	for _, tt := range tests {
+		err := Verify(&compiler.Bytecode{Instructions: tt.instructions, Constants: tt.constants})
+
+		var verifyErr *VerifyError
+		if !errors.As(err, &verifyErr) {
+			t.Errorf("%s: expected verify error. got=%v", tt.name, err)
+		}
+	}
+}
+
+func concatInstructions(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+
+	return out
+}
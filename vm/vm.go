@@ -1,11 +1,9 @@
 package vm
 
 import (
-	"fmt"
+	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/compiler/compiler"
 	"github.com/looplanguage/loop/models/object"
-	"github.com/looplanguage/lpvm/flags"
-	"log"
 )
 
 const StackSize = 2048
@@ -18,6 +16,7 @@ var Null = &object.Null{}
 
 type VM struct {
 	constants []object.Object
+	ids       map[*object.CompiledFunction]int
 	variables []object.Object
 
 	stack []object.Object
@@ -27,6 +26,30 @@ type VM struct {
 
 	frames     []*Frame
 	frameIndex int
+
+	lastIp int
+	lastOp code.OpCode
+
+	lineTables map[*object.CompiledFunction][]LineEntry
+
+	hook   InstructionHook
+	inHook bool
+
+	profiler *profiler
+	sampler  *sampler
+	tracer   *tracer
+
+	memo      *MemoCache
+	memoStats MemoStats
+	purity    *PurityReport
+
+	integerMode IntegerMode
+
+	executed    uint64
+	meterFuel   bool
+	fuelLimit   uint64
+	fuelUsed    uint64
+	opcodeCosts []uint64
 }
 
 func Create(bytecode *compiler.Bytecode) *VM {
@@ -35,12 +58,12 @@ func Create(bytecode *compiler.Bytecode) *VM {
 	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
-	MemoizationEnabled = flags.OptimizationEnabled("memoize")
 
 	frames[0] = mainFrame
 
 	return &VM{
 		constants:  bytecode.Constants,
+		ids:        FunctionIds(bytecode.Constants),
 		stack:      make([]object.Object, StackSize),
 		sp:         0,
 		globals:    make([]object.Object, GlobalsSize),
@@ -50,12 +73,46 @@ func Create(bytecode *compiler.Bytecode) *VM {
 	}
 }
 
+// FunctionIds gives every compiled function an id, its constant index plus one, the main program has id 0. The
+// compiler leaves the Id of every function at 0 and the constants may be shared, so the ids are kept apart from them.
+func FunctionIds(constants []object.Object) map[*object.CompiledFunction]int {
+	ids := map[*object.CompiledFunction]int{}
+
+	for i, constant := range constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if _, ok := ids[fn]; !ok {
+				ids[fn] = i + 1
+			}
+		}
+	}
+
+	return ids
+}
+
+// FunctionId returns the id of a compiled function of the bytecode, see FunctionIds.
+func (vm *VM) FunctionId(fn *object.CompiledFunction) int {
+	return vm.ids[fn]
+}
+
 func CreateWithStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm := Create(bytecode)
 	vm.globals = s
 	return vm
 }
 
+// CreateWithState is like CreateWithStore, but also keeps the variables of an earlier VM. The REPL uses it to run
+// every input with the state left behind by the previous ones.
+func CreateWithState(bytecode *compiler.Bytecode, globals []object.Object, variables []object.Object) *VM {
+	vm := CreateWithStore(bytecode, globals)
+	vm.variables = variables
+	return vm
+}
+
+// SetStart makes Run begin at the given offset of the main program instead of at its first instruction.
+func (vm *VM) SetStart(ip int) {
+	vm.frames[0].ip = ip - 1
+}
+
 func (vm *VM) callFunction(numArgs int) error {
 	switch fn := vm.stack[vm.sp-1-numArgs].(type) {
 	case *object.Closure:
@@ -64,13 +121,29 @@ func (vm *VM) callFunction(numArgs int) error {
 		return vm.callBuiltinFunction(fn, numArgs)
 	}
 
-	return fmt.Errorf("attempt to call non-function. got=%q", vm.stack[vm.sp-1].Type())
+	return &NotCallableError{Type: vm.stack[vm.sp-1-numArgs].Type()}
 }
 
 func (vm *VM) callBuiltinFunction(fn *object.BuiltinFunction, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
+	var profile *activation
+	if vm.profiler != nil {
+		profile = vm.profiler.enter(vm.currentFrame().closure.Fn, vm.lastIp, builtinLabel(fn))
+	}
+
+	traced := vm.tracer != nil && vm.beginTrace(builtinLabel(fn), "builtin")
+
 	result := fn.Function(args)
+
+	if traced {
+		vm.tracer.end(builtinLabel(fn), "builtin")
+	}
+
+	if profile != nil {
+		vm.profiler.leave(profile, nil)
+	}
+
 	vm.sp = vm.sp - numArgs - 1
 
 	if result != nil {
@@ -80,82 +153,88 @@ func (vm *VM) callBuiltinFunction(fn *object.BuiltinFunction, numArgs int) error
 	return vm.push(Null)
 }
 
-type MemoizedFunction struct {
-	Id     int
-	Args   []object.Object
-	Result object.Object
-}
+func (vm *VM) callUserClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return &ArgumentCountError{Expected: cl.Fn.NumParameters, Got: numArgs}
+	}
 
-type MemoizedKey struct {
-	Id   int
-	Args string
-}
+	if vm.frameIndex >= MaxFrames {
+		return &StackOverflowError{Frames: true}
+	}
 
-// Cache needs to be on function ID & arguments, **NOT** just its ID!
-var MemoizedFunctions = make(map[MemoizedKey]*MemoizedFunction, 5000)
-var GetFunctionResult *MemoizedFunction
-var MemoizationEnabled = false
+	if vm.sp-numArgs+cl.Fn.NumLocals > StackSize {
+		return &StackOverflowError{}
+	}
 
-func (vm *VM) callUserClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments. expected=%d. got=%d", cl.Fn.NumParameters, numArgs)
+	if vm.memo != nil {
+		return vm.callMemoized(cl, numArgs)
 	}
 
-	if MemoizationEnabled {
-		var args = []object.Object{}
+	vm.enterClosure(cl, numArgs)
 
-		arg := 0
+	return nil
+}
 
-		for arg < numArgs {
-			args = append(args, vm.stack[vm.sp-numArgs+arg])
-			arg++
-		}
+// callMemoized returns the cached result of a call when there is one, otherwise the result is stored once the frame
+// returns. Nested and recursive calls each have their own frame, so results are never stored against the wrong call.
+func (vm *VM) callMemoized(cl *object.Closure, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	for i, arg := range vm.stack[vm.sp-numArgs : vm.sp] {
+		args[i] = copyValue(arg)
+	}
 
-		concatArgs := ""
+	key, ok := memoizedKey(vm.FunctionId(cl.Fn), args)
+	if !ok || !vm.purity.IsPure(cl.Fn) {
+		vm.enterClosure(cl, numArgs)
+		return nil
+	}
 
-		for _, a := range args {
-			concatArgs += a.Type() + a.Inspect()
-		}
+	if cached := vm.memo.get(key, args); cached != nil {
+		vm.memoStats.Hits++
 
-		var MF *MemoizedFunction
-		key := MemoizedKey{
-			Id:   cl.Fn.Id,
-			Args: concatArgs,
+		if vm.profiler != nil {
+			vm.profiler.leaveFunction(vm.profiler.enterFunction(vm.currentFrame().closure.Fn, vm.lastIp, cl.Fn), cl.Fn)
 		}
 
-		MF = MemoizedFunctions[key]
+		if vm.tracer != nil {
+			vm.traceMemoized(functionName(vm.FunctionId(cl.Fn)))
+		}
 
-		if MF != nil && MF.Result != nil {
-			vm.sp = vm.sp - numArgs
+		// Replace the function and its arguments with the result, as if the call returned
+		vm.sp = vm.sp - numArgs - 1
 
-			for i := 0; i < numArgs; i++ {
-				vm.pop()
-			}
+		return vm.push(copyValue(cached.Result))
+	}
 
-			vm.push(MF.Result)
-		} else {
-			val := &MemoizedFunction{
-				Id:   cl.Fn.Id,
-				Args: args,
-			}
+	vm.memoStats.Misses++
 
-			MemoizedFunctions[key] = val
+	frame := vm.enterClosure(cl, numArgs)
+	frame.memoKey = key
+	frame.memoResult = &MemoizedFunction{
+		Id:   vm.FunctionId(cl.Fn),
+		Args: args,
+	}
 
-			GetFunctionResult = val
+	return nil
+}
 
-			frame := NewFrame(cl, vm.sp-numArgs)
-			vm.pushFrame(frame)
+// enterClosure pushes the frame for a call whose arguments are on top of the stack.
+func (vm *VM) enterClosure(cl *object.Closure, numArgs int) *Frame {
+	frame := NewFrame(cl, vm.sp-numArgs)
 
-			vm.sp = frame.basePointer + cl.Fn.NumLocals
-		}
-	} else {
-		frame := NewFrame(cl, vm.sp-numArgs)
-		vm.pushFrame(frame)
+	if vm.profiler != nil {
+		frame.profile = vm.profiler.enterFunction(vm.currentFrame().closure.Fn, vm.lastIp, cl.Fn)
+	}
 
-		vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.tracer != nil {
+		frame.traced = vm.beginTrace(functionName(vm.FunctionId(cl.Fn)), "function")
 	}
 
-	return nil
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return frame
 }
 
 func (vm *VM) currentFrame() *Frame {
@@ -168,10 +247,14 @@ func (vm *VM) pushFrame(f *Frame) {
 }
 
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	if constIndex >= len(vm.constants) {
+		return &OutOfRangeError{Kind: "constant", Index: constIndex, Length: len(vm.constants)}
+	}
+
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
 	if !ok {
-		log.Fatalf("type is not function. got=%+v\n", constant)
+		return &TypeError{OpCode: code.OpClosure, Types: []string{constant.Type()}}
 	}
 
 	free := make([]object.Object, numFree)
@@ -185,14 +268,37 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 }
 
 func (vm *VM) popFrame(returnValue object.Object) *Frame {
-	if GetFunctionResult != nil {
-		GetFunctionResult.Result = returnValue
-		GetFunctionResult = nil
+	vm.frameIndex--
+	frame := vm.frames[vm.frameIndex]
+
+	if frame.memoResult != nil {
+		// Functions without a return value result in null
+		if returnValue == nil {
+			returnValue = Null
+		}
+
+		// Arrays and hashmaps can be changed by the caller, which would change the cached result as well
+		switch returnValue.(type) {
+		case *object.Array, *object.HashMap:
+		default:
+			frame.memoResult.Result = copyValue(returnValue)
+			vm.memoStats.Evictions += vm.memo.store(frame.memoKey, frame.memoResult)
+		}
+
+		frame.memoResult = nil
 	}
 
-	vm.frameIndex--
+	if frame.profile != nil {
+		vm.profiler.leaveFunction(frame.profile, frame.closure.Fn)
+		frame.profile = nil
+	}
+
+	if frame.traced {
+		vm.tracer.end(functionName(vm.FunctionId(frame.closure.Fn)), "function")
+		frame.traced = false
+	}
 
-	return vm.frames[vm.frameIndex]
+	return frame
 }
 
 func (vm *VM) pop() object.Object {
@@ -203,7 +309,7 @@ func (vm *VM) pop() object.Object {
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return &StackOverflowError{}
 	}
 
 	vm.stack[vm.sp] = o
@@ -221,5 +327,9 @@ func (vm *VM) StackTop() object.Object {
 }
 
 func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.sp >= StackSize {
+		return nil
+	}
+
 	return vm.stack[vm.sp]
 }
@@ -1,13 +1,22 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"github.com/looplanguage/compiler/code"
 	"github.com/looplanguage/compiler/compiler"
 	"github.com/looplanguage/loop/lexer"
 	"github.com/looplanguage/loop/models/ast"
 	"github.com/looplanguage/loop/models/object"
 	"github.com/looplanguage/loop/parser"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -36,6 +45,26 @@ func TestBooleanExpressions(t *testing.T) {
 		{input: "true == false", expected: false},
 		{input: "true == true", expected: true},
 		{input: "1 == 10", expected: false},
+		{input: "1 != 10", expected: true},
+		{input: "2 > 1", expected: true},
+		{input: "2 < 1", expected: false},
+		{input: `"loop" == "loop"`, expected: true},
+		{input: `"loop" != "loop"`, expected: false},
+		{input: `"abc" < "abd"`, expected: true},
+		{input: `"b" > "abc"`, expected: true},
+		{input: `"" < "a"`, expected: true},
+		{input: `1 == "1"`, expected: false},
+		{input: `1 != "1"`, expected: true},
+		{input: "true == 1", expected: false},
+		{input: "[1, [2, 3]] == [1, [2, 3]]", expected: true},
+		{input: "[1, 2] == [1, 3]", expected: false},
+		{input: "[1, 2] == [1, 2, 3]", expected: false},
+		{input: `{1: "a", "b": [2]} == {"b": [2], 1: "a"}`, expected: true},
+		{input: `{1: "a"} == {1: "b"}`, expected: false},
+		{input: `{1: "a"} == {2: "a"}`, expected: false},
+		{input: "var a = [1]; var b = [1]; a[0] = a; b[0] = b; a == b", expected: true},
+		{input: "var f = fun() { return 1 }; f == f", expected: true},
+		{input: "fun() { return 1 } == fun() { return 1 }", expected: false},
 	}
 
 	runVmTests(t, tests)
@@ -123,6 +152,7 @@ func TestVM_IndexExpressions(t *testing.T) {
 		{"{0: 100, 1: 50 * 1}[1]", 50},
 		{"{0 + 1: 100, 2: 2, 3: 3}[3]", 3},
 		{"{0 + 1: 100 * 2}[2]", Null},
+		{"[1, 2, 3][0 - 1]", Null},
 	}
 
 	runVmTests(t, tests)
@@ -184,6 +214,310 @@ func TestVM_CallExpressionsWrongArguments(t *testing.T) {
 	}
 }
 
+func TestVM_RuntimeErrorBacktrace(t *testing.T) {
+	input := `
+	var f = fun(x) { return x };
+	var g = fun() { return f(1, 2) };
+	g();
+	`
+
+	program := parse(input)
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+	err = vm.Run(nil)
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected runtime error. got=%T (%v)", err, err)
+	}
+
+	if runtimeErr.OpCode != code.OpCall {
+		t.Errorf("wrong opcode. want=%s. got=%s", OpcodeName(code.OpCall), OpcodeName(runtimeErr.OpCode))
+	}
+
+	if len(runtimeErr.Trace) != 2 {
+		t.Fatalf("wrong backtrace length. want=2. got=%d", len(runtimeErr.Trace))
+	}
+
+	inner := runtimeErr.Trace[0]
+	if inner.Function == nil || inner.Function.NumParameters != 0 {
+		t.Fatalf("innermost frame is not function g. got=%+v", inner)
+	}
+
+	if inner.FunctionId != vm.FunctionId(inner.Function) || inner.FunctionId == 0 {
+		t.Errorf("wrong function id. got=%d", inner.FunctionId)
+	}
+
+	if op := code.OpCode(inner.Function.Instructions[inner.Ip]); op != code.OpCall {
+		t.Errorf("innermost ip does not point at the call. got=%s", OpcodeName(op))
+	}
+
+	outer := runtimeErr.Trace[1]
+	if outer.Function != nil {
+		t.Errorf("outermost frame is not main. got=%+v", outer)
+	}
+
+	if op := code.OpCode(comp.Bytecode().Instructions[outer.Ip]); op != code.OpCall {
+		t.Errorf("main ip does not point at the call. got=%s", OpcodeName(op))
+	}
+}
+
+func TestVM_FunctionIds(t *testing.T) {
+	program := parse("var f = fun() { return 1 }; var g = fun() { return f() }; g()")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+
+	vm := Create(bytecode)
+	err = vm.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		if fn.Id != 0 {
+			t.Errorf("expected the VM to leave constant %d unchanged. got id=%d", i, fn.Id)
+		}
+
+		if vm.FunctionId(fn) != i+1 {
+			t.Errorf("wrong id for constant %d. want=%d. got=%d", i, i+1, vm.FunctionId(fn))
+		}
+	}
+
+	if vm.FunctionId(&object.CompiledFunction{}) != 0 {
+		t.Errorf("expected id 0 for a function outside of the bytecode")
+	}
+}
+
+func TestVM_RuntimeErrorTypes(t *testing.T) {
+	tests := []struct {
+		input  string
+		opCode code.OpCode
+		check  func(err error) bool
+	}{
+		{`1 * "a"`, code.OpMultiply, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpMultiply && len(typeErr.Types) == 2
+		}},
+		{`"a" - 1`, code.OpSubtract, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpSubtract
+		}},
+		{`[1] + 1`, code.OpAdd, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpAdd
+		}},
+		{`1 > "a"`, code.OpGreaterThan, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && err.Error() == "unsupported operand types for OpGreaterThan: INTEGER, STRING"
+		}},
+		{"[1] < [2]", code.OpGreaterThan, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpGreaterThan
+		}},
+		{"10 / 0", code.OpDivide, func(err error) bool {
+			return errors.Is(err, ErrDivisionByZero)
+		}},
+		{"var a = [1]; a[5] = 2", code.OpSetIndex, func(err error) bool {
+			var indexErr *IndexError
+			return errors.As(err, &indexErr) && indexErr.Index == 5 && indexErr.Length == 1
+		}},
+		{"if (1) { 2 }", code.OpJumpIfNotTrue, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && err.Error() == "unsupported operand types for OpJumpIfNotTrue: INTEGER"
+		}},
+		{"1[0]", code.OpIndex, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpIndex && len(typeErr.Types) == 2
+		}},
+		{"{1: 2}[[1]]", code.OpIndex, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpIndex && typeErr.Types[1] == object.ARRAY
+		}},
+		{"var a = [1]; a[[0]] = 2", code.OpSetIndex, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpSetIndex && typeErr.Types[1] == object.ARRAY
+		}},
+		{"var h = {1: 2}; h[[1]] = 2", code.OpSetIndex, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpSetIndex && typeErr.Types[0] == object.HASHMAP
+		}},
+		{"{[1]: 2}", code.OpHash, func(err error) bool {
+			var typeErr *TypeError
+			return errors.As(err, &typeErr) && typeErr.OpCode == code.OpHash
+		}},
+		{"1()", code.OpCall, func(err error) bool {
+			var callErr *NotCallableError
+			return errors.As(err, &callErr) && callErr.Type == object.INTEGER
+		}},
+		{"fun(x) { return x }()", code.OpCall, func(err error) bool {
+			var countErr *ArgumentCountError
+			return errors.As(err, &countErr) && countErr.Expected == 1 && countErr.Got == 0
+		}},
+		{"var f = fun() { return f() }; f()", code.OpCall, func(err error) bool {
+			var overflowErr *StackOverflowError
+			return errors.As(err, &overflowErr) && overflowErr.Frames
+		}},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		err = vm.Run(nil)
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("expected runtime error for %q. got=%v", tt.input, err)
+		}
+
+		if runtimeErr.OpCode != tt.opCode {
+			t.Errorf("wrong opcode for %q. want=%s. got=%s", tt.input, OpcodeName(tt.opCode), OpcodeName(runtimeErr.OpCode))
+		}
+
+		if !tt.check(err) {
+			t.Errorf("wrong error for %q. got=%T (%v)", tt.input, runtimeErr.Err, err)
+		}
+	}
+}
+
+func TestVM_UndefinedGlobal(t *testing.T) {
+	vm := Create(&compiler.Bytecode{Instructions: code.Make(code.OpGetGlobal, 3)})
+	err := vm.Run(nil)
+
+	var undefinedErr *UndefinedGlobalError
+	if !errors.As(err, &undefinedErr) || undefinedErr.Index != 3 {
+		t.Errorf("expected an undefined global error. got=%v", err)
+	}
+}
+
+func TestVM_OutOfRange(t *testing.T) {
+	tests := []struct {
+		instructions code.Instructions
+		kind         string
+	}{
+		{code.Make(code.OpConstant, 5), "constant"},
+		{code.Make(code.OpGetLocal, 0), "local"},
+		{concatInstructions(code.Make(code.OpNull), code.Make(code.OpSetLocal, 0)), "local"},
+		{code.Make(code.OpGetFree, 0), "free variable"},
+		{code.Make(code.OpGetBuiltinFunction, 255), "builtin function"},
+		{code.Make(code.OpClosure, 5, 0), "constant"},
+	}
+
+	for _, tt := range tests {
+		vm := Create(&compiler.Bytecode{Instructions: tt.instructions})
+		err := vm.Run(nil)
+
+		var rangeErr *OutOfRangeError
+		if !errors.As(err, &rangeErr) || rangeErr.Kind != tt.kind {
+			t.Errorf("expected a %s out of range error for %s. got=%v", tt.kind, OpcodeName(code.OpCode(tt.instructions[0])), err)
+		}
+	}
+}
+
+func TestVM_HookPanic(t *testing.T) {
+	vm := Create(&compiler.Bytecode{Instructions: code.Make(code.OpTrue)})
+	vm.SetInstructionHook(func(op code.OpCode) error {
+		panic("hook")
+	})
+
+	defer func() {
+		if r := recover(); r != "hook" {
+			t.Errorf("expected the panic of the hook. got=%v", r)
+		}
+	}()
+
+	err := vm.Run(nil)
+	t.Errorf("expected the panic of the hook to reach the caller. got=%v", err)
+}
+
+func TestVM_MalformedBytecode(t *testing.T) {
+	tests := []struct {
+		instructions []code.Instructions
+		constants    []object.Object
+	}{
+		{[]code.Instructions{code.Make(code.OpPop)}, nil},
+		{[]code.Instructions{code.Make(code.OpGetGlobal, 0)}, nil},
+		{[]code.Instructions{code.Make(code.OpConstant, 5)}, nil},
+		{[]code.Instructions{code.Make(code.OpGetBuiltinFunction, 255)}, nil},
+		{[]code.Instructions{code.Make(code.OpClosure, 0, 0)}, []object.Object{&object.Integer{Value: 1}}},
+		{[]code.Instructions{code.Make(code.OpConstant, 0), code.Make(code.OpCall, 0)}, []object.Object{&object.Integer{Value: 1}}},
+		{[]code.Instructions{code.Make(code.OpConstant, 0), code.Make(code.OpConstant, 0), code.Make(code.OpIndex)}, []object.Object{&object.Integer{Value: 1}}},
+	}
+
+	for i, tt := range tests {
+		bytecode := &compiler.Bytecode{Constants: tt.constants}
+		for _, ins := range tt.instructions {
+			bytecode.Instructions = append(bytecode.Instructions, ins...)
+		}
+
+		vm := Create(bytecode)
+		err := vm.Run(nil)
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("test %d: expected runtime error. got=%v", i, err)
+		}
+	}
+}
+
+func TestVM_SourceMap(t *testing.T) {
+	program := parse("1 + 2; 10 / 0")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	sourceMap, err := LoadSourceMap(strings.NewReader(`{
+		"main": [
+			{"offset": 8, "file": "test.lp", "line": 2, "column": 1},
+			{"offset": 0, "file": "test.lp", "line": 1, "column": 1}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("source map error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+	vm.SetSourceMap(sourceMap)
+	err = vm.Run(nil)
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected runtime error. got=%v", err)
+	}
+
+	expected := "test.lp:2:1: division by zero"
+	if err.Error() != expected {
+		t.Errorf("wrong error message. want=%q. got=%q", expected, err.Error())
+	}
+
+	position, ok := vm.Position(nil, 3)
+	if !ok || position.Line != 1 {
+		t.Errorf("wrong position for offset 3. got=%+v (%t)", position, ok)
+	}
+}
+
 func TestVM_FunctionBindings(t *testing.T) {
 	tests := []vmTestCase{
 		{"var test = fun() { var one = 1; return one }; test()", 1},
@@ -339,6 +673,117 @@ func TestVM_EarlyReturns(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestVM_WhileLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"var i = 0; while (i < 3) { i = i + 1 }; i", 3},
+		{"var i = 5; while (i < 3) { i = i + 1 }; i", 5},
+		{"fun() { var i = 5; while (i < 3) { i = i + 1 }; return i }()", 5},
+		{"var i = 0; fun() { while (i < 3) { i = i + 1 } }(); i", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestVM_RunContextCancelled(t *testing.T) {
+	program := parse("fun() { return 20 }()")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vm := Create(comp.Bytecode())
+	err = vm.RunContext(ctx, nil)
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("wrong VM error: want=%q, got=%q", ErrCancelled, err)
+	}
+
+	if vm.frameIndex != 1 {
+		t.Fatalf("function was called after cancellation. frameIndex=%d", vm.frameIndex)
+	}
+}
+
+func TestVM_RunContextDeadline(t *testing.T) {
+	// An endless loop: jump back to the start of the instructions forever
+	bytecode := &compiler.Bytecode{Instructions: code.Make(code.OpJump, 0)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	vm := Create(bytecode)
+	err := vm.RunContext(ctx, nil)
+	if !errors.Is(err, ErrDeadlineExceeded) {
+		t.Fatalf("wrong VM error: want=%q, got=%q", ErrDeadlineExceeded, err)
+	}
+}
+
+func TestVM_Fuel(t *testing.T) {
+	tests := []struct {
+		input    string
+		limit    uint64
+		costs    map[code.OpCode]uint64
+		executed uint64
+	}{
+		{"1 + 2", 3, nil, 3},
+		{"1 + 2", 1, nil, 1},
+		{"1 + 2", 12, map[code.OpCode]uint64{code.OpAdd: 10}, 3},
+		{"fun() { return 20 }()", 10, nil, 1},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		vm.SetFuel(tt.limit)
+		for op, cost := range tt.costs {
+			vm.SetOpcodeCost(op, cost)
+		}
+
+		err = vm.Run(nil)
+
+		var fuelErr *OutOfFuelError
+		if !errors.As(err, &fuelErr) {
+			t.Fatalf("expected out of fuel error. got=%v", err)
+		}
+
+		if fuelErr.Executed != tt.executed {
+			t.Errorf("wrong amount of instructions executed. want=%d. got=%d", tt.executed, fuelErr.Executed)
+		}
+
+		if vm.FuelUsed() > tt.limit {
+			t.Errorf("fuel used exceeds limit. limit=%d. got=%d", tt.limit, vm.FuelUsed())
+		}
+	}
+}
+
+func TestVM_OpcodeCostBeforeFuel(t *testing.T) {
+	program := parse("1 + 2")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+	vm.SetOpcodeCost(code.OpAdd, 10)
+	vm.SetFuel(12)
+
+	err = vm.Run(nil)
+
+	var fuelErr *OutOfFuelError
+	if !errors.As(err, &fuelErr) || fuelErr.Executed != 3 {
+		t.Errorf("expected the cost to be kept by SetFuel. got=%v", err)
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -490,3 +935,644 @@ func parse(input string) *ast.Program {
 	p := parser.Create(l)
 	return p.Parse()
 }
+
+func TestVM_Profile(t *testing.T) {
+	program := parse("var double = fun(x) { return x * 2 }; double(1) + double(2) + len([1])")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+
+	if vm.Profile() != nil {
+		t.Fatalf("expected no profile before profiling is enabled")
+	}
+
+	vm.EnableProfiling()
+
+	err = vm.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	profile := vm.Profile()
+
+	var count uint64
+	for _, entry := range profile.Opcodes {
+		count += entry.Count
+	}
+
+	if count != vm.InstructionsExecuted() {
+		t.Errorf("wrong opcode count. want=%d. got=%d", vm.InstructionsExecuted(), count)
+	}
+
+	functions := map[int]FunctionProfile{}
+	for _, entry := range profile.Functions {
+		functions[entry.FunctionId] = entry
+	}
+
+	if len(functions) != 2 {
+		t.Fatalf("wrong amount of functions. want=2. got=%d", len(functions))
+	}
+
+	if functions[0].Calls != 1 || functions[0].Total != profile.Total {
+		t.Errorf("wrong main profile. got=%+v", functions[0])
+	}
+
+	double := functions[2]
+	if double.Calls != 2 || double.Instructions != 8 {
+		t.Errorf("wrong function profile. got=%+v", double)
+	}
+
+	if double.Total < double.Self {
+		t.Errorf("total time is less than self time. got=%+v", double)
+	}
+
+	callees := map[string]uint64{}
+	for _, entry := range profile.CallSites {
+		if entry.FunctionId != 0 {
+			t.Errorf("wrong caller. want=0. got=%d", entry.FunctionId)
+		}
+
+		callees[entry.Callee] += entry.Calls
+	}
+
+	if len(profile.CallSites) != 3 || callees["function 2"] != 2 || callees["len"] != 1 {
+		t.Errorf("wrong call sites. got=%+v", profile.CallSites)
+	}
+
+	var out strings.Builder
+	profile.Fprint(&out)
+
+	if !strings.Contains(out.String(), "OpCall") || !strings.Contains(out.String(), "function 2") {
+		t.Errorf("report is missing entries. got=\n%s", out.String())
+	}
+}
+
+func TestVM_CPUProfile(t *testing.T) {
+	program := parse("var fib = fun(n) { if (n < 2) { return n }; return fib(n - 1) + fib(n - 2) }; fib(10)")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := Create(comp.Bytecode())
+
+	// Every check of the clock takes a sample
+	vm.StartCPUProfile(time.Nanosecond)
+
+	err = vm.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	profile := vm.StopCPUProfile()
+
+	if len(profile.Samples) == 0 {
+		t.Fatalf("expected samples")
+	}
+
+	for _, sample := range profile.Samples {
+		if sample.Count <= 0 {
+			t.Errorf("wrong sample count. got=%d", sample.Count)
+		}
+
+		outermost := sample.Stack[len(sample.Stack)-1]
+		if outermost.Function != nil {
+			t.Errorf("outermost frame is not main. got=%s", outermost)
+		}
+	}
+
+	if vm.StopCPUProfile() != nil {
+		t.Errorf("expected no profile after it was stopped")
+	}
+}
+
+func TestVM_Trace(t *testing.T) {
+	tests := []struct {
+		input   string
+		sample  uint64
+		memoize bool
+		events  []string
+	}{
+		{"var double = fun(x) { return x * 2 }; double(1) + len([1])", 0, false, []string{"B function 2", "E function 2", "B len", "E len"}},
+		{"var f = fun(x) { return len(x) }; f([1]); f([2]); f([3])", 3, false, []string{"B function 1", "E function 1", "B len", "E len"}},
+		{"var f = fun() { return 1 / 0 }; f()", 1, false, []string{"B function 3", "E function 3"}},
+		// The second call returns the memoized result without running
+		{"var double = fun(x) { return x * 2 }; double(1) + double(1)", 0, true, []string{"B function 2", "E function 2", "i function 2"}},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		if tt.memoize {
+			vm.EnableMemoization()
+		}
+
+		vm.StartTrace(tt.sample)
+		vm.Run(nil)
+
+		trace := vm.StopTrace()
+
+		var events []string
+		for i, event := range trace.Events {
+			events = append(events, event.Phase+" "+event.Name)
+
+			if i > 0 && event.Timestamp < trace.Events[i-1].Timestamp {
+				t.Errorf("events are not ordered. got=%v", trace.Events)
+			}
+
+			if event.Phase == "i" && event.Args["memoized"] != true {
+				t.Errorf("expected a memoized call. got=%v", event.Args)
+			}
+		}
+
+		if strings.Join(events, ", ") != strings.Join(tt.events, ", ") {
+			t.Errorf("wrong events for %q. want=%v. got=%v", tt.input, tt.events, events)
+		}
+
+		var out strings.Builder
+		err = trace.WriteJSON(&out)
+		if err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+
+		if !strings.HasPrefix(out.String(), `{"displayTimeUnit":"ns","traceEvents":[{"name":`) {
+			t.Errorf("wrong json. got=%s", out.String())
+		}
+	}
+}
+
+func TestVM_MemoCache(t *testing.T) {
+	program := parse("var double = fun(x) { return x * 2 }; double(2) + double(2) + double(3)")
+	comp := compiler.Create()
+	err := comp.Compile(program, "", "", "")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	plain := Create(comp.Bytecode())
+	if plain.MemoCache() != nil {
+		t.Fatalf("expected memoization to be disabled by default")
+	}
+
+	cache := NewMemoCache()
+	machines := make([]*VM, 4)
+
+	for i := range machines {
+		machines[i] = Create(comp.Bytecode())
+		machines[i].SetMemoCache(cache)
+	}
+
+	done := make(chan error)
+	for _, machine := range machines {
+		go func(machine *VM) {
+			done <- machine.Run(nil)
+		}(machine)
+	}
+
+	for range machines {
+		if err := <-done; err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	err = plain.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	for _, machine := range append(machines, plain) {
+		err := testIntegerObject(14, machine.LastPoppedStackElem())
+		if err != nil {
+			t.Errorf("wrong result: %s", err)
+		}
+	}
+
+	if cache.Len() != 2 {
+		t.Errorf("wrong amount of cached results. want=2. got=%d", cache.Len())
+	}
+
+	isolated := Create(comp.Bytecode())
+	isolated.EnableMemoization()
+
+	if isolated.MemoCache() == cache || isolated.MemoCache().Len() != 0 {
+		t.Errorf("expected a private cache")
+	}
+}
+
+func TestVM_Memoization(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+		cached   int
+	}{
+		// Nested calls, the result of g must not be stored against the key of f
+		{"var g = fun(x) { return x + 1 }; var f = fun(x) { return x * 2 }; f(g(1)) + f(g(1)) + g(1)", 10, 2},
+		{"var fib = fun(x) { if (x < 2) { return x }; return fib(x - 1) + fib(x - 2) }; fib(15)", 610, 16},
+		{"var f = fun(x) { if (x > 1) { return 10 }; return 20 }; f(2) + f(0) + f(2)", 40, 2},
+		{"var f = fun() { return 5 }; f() + f()", 10, 1},
+		{"var add = fun(a, b) { return a + b }; add(1, 2) + add(1, 2) + add(2, 1)", 9, 2},
+		{"var f = fun() { }; f(); f()", Null, 1},
+		// Reassigning a parameter changes its value in place, which must not change the cached result
+		{"var square = fun(x) { return x * x }; var bump = fun(y) { y = y + 1; return y }; bump(square(3)); square(3)", 9, 1},
+		{"var square = fun(x) { return x * x }; var bump = fun(y) { y = y + 1; return y }; square(3); bump(square(3)); square(3)", 9, 1},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		vm.EnableMemoization()
+
+		err = vm.Run(nil)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+
+		if vm.MemoCache().Len() != tt.cached {
+			t.Errorf("wrong amount of cached results for %q. want=%d. got=%d", tt.input, tt.cached, vm.MemoCache().Len())
+		}
+
+		if vm.sp != 0 {
+			t.Errorf("stack not empty for %q. got=%d", tt.input, vm.sp)
+		}
+	}
+}
+
+func TestVM_IntegerModes(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     IntegerMode
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1", IntegerWrap, -9223372036854775808},
+		{"9223372036854775807 + 1", IntegerChecked, ErrIntegerOverflow},
+		{"9223372036854775807 + 1", IntegerPromote, bigInteger("9223372036854775808")},
+		{"4611686018427387904 * 2", IntegerChecked, ErrIntegerOverflow},
+		{"4611686018427387904 * 2", IntegerPromote, bigInteger("9223372036854775808")},
+		{"0 - 9223372036854775807 - 2", IntegerChecked, ErrIntegerOverflow},
+		{"0 - 9223372036854775807 - 2", IntegerPromote, bigInteger("-9223372036854775809")},
+		{"(0 - 9223372036854775807 - 1) / (0 - 1)", IntegerChecked, ErrIntegerOverflow},
+		{"(0 - 9223372036854775807 - 1) / (0 - 1)", IntegerPromote, bigInteger("9223372036854775808")},
+		{"9223372036854775807 + 1 - 1", IntegerPromote, 9223372036854775807},
+		{"(9223372036854775807 + 1) * (9223372036854775807 + 1) / (9223372036854775807 + 1)", IntegerPromote, bigInteger("9223372036854775808")},
+		{"(9223372036854775807 + 1) / 0", IntegerPromote, ErrDivisionByZero},
+		{"9223372036854775807 + 1 > 9223372036854775807", IntegerPromote, true},
+		{"9223372036854775807 < 9223372036854775807 + 1", IntegerPromote, true},
+		{"9223372036854775807 + 1 == 9223372036854775807 + 1", IntegerPromote, true},
+		{"9223372036854775807 + 1 == 9223372036854775807", IntegerPromote, false},
+		{`"n=" + (9223372036854775807 + 1)`, IntegerPromote, "n=9223372036854775808"},
+		{"{9223372036854775807 + 1: 5}[9223372036854775807 + 1]", IntegerPromote, 5},
+		{"fun(x) { x = x * x; return x }(4294967296)", IntegerPromote, bigInteger("18446744073709551616")},
+		{"fun(x) { x = x * x; x = x * x; return x }(4294967296)", IntegerPromote, bigInteger("340282366920938463463374607431768211456")},
+		{"fun(x) { x = x - x; return x }(9223372036854775807 + 1)", IntegerPromote, 0},
+		{"1 + 2 * 3", IntegerChecked, 7},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.Create()
+		err := comp.Compile(program, "", "", "")
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := Create(comp.Bytecode())
+		vm.SetIntegerMode(tt.mode)
+
+		err = vm.Run(nil)
+
+		if expectedErr, ok := tt.expected.(error); ok {
+			if !errors.Is(err, expectedErr) {
+				t.Errorf("expected error %q for %q. got=%v", expectedErr, tt.input, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		result := vm.LastPoppedStackElem()
+
+		if expected, ok := tt.expected.(*BigInteger); ok {
+			if _, ok := result.(*BigInteger); !ok || !valuesEqual(expected, result, nil) {
+				t.Errorf("wrong result for %q. want=%s. got=%s (%s)", tt.input, expected.Inspect(), result.Inspect(), result.Type())
+			}
+
+			continue
+		}
+
+		testExpectedObject(t, tt.expected, result)
+	}
+}
+
+func bigInteger(value string) *BigInteger {
+	i, _ := new(big.Int).SetString(value, 10)
+	return &BigInteger{Value: i}
+}
+
+func TestVM_Floats(t *testing.T) {
+	tests := []struct {
+		left     object.Object
+		op       code.OpCode
+		right    object.Object
+		expected object.Object
+	}{
+		{&Float{Value: 1.5}, code.OpAdd, &Float{Value: 2.25}, &Float{Value: 3.75}},
+		{&Float{Value: 1.5}, code.OpAdd, &object.Integer{Value: 2}, &Float{Value: 3.5}},
+		{&object.Integer{Value: 2}, code.OpSubtract, &Float{Value: 0.5}, &Float{Value: 1.5}},
+		{&object.Integer{Value: 3}, code.OpMultiply, &Float{Value: 0.5}, &Float{Value: 1.5}},
+		{&object.Integer{Value: 7}, code.OpDivide, &Float{Value: 2}, &Float{Value: 3.5}},
+		{&Float{Value: 7}, code.OpDivide, &object.Integer{Value: 2}, &Float{Value: 3.5}},
+		{&object.Integer{Value: 7}, code.OpDivide, &object.Integer{Value: 2}, &object.Integer{Value: 3}},
+		{bigInteger("9223372036854775808"), code.OpMultiply, &Float{Value: 0.5}, &Float{Value: 4611686018427387904}},
+		{&object.String{Value: "f="}, code.OpAdd, &Float{Value: 2}, &object.String{Value: "f=2.0"}},
+		{&Float{Value: 0.1}, code.OpAdd, &object.String{Value: "!"}, &object.String{Value: "0.1!"}},
+		{&object.String{Value: ""}, code.OpAdd, &Float{Value: 1e21}, &object.String{Value: "1e+21"}},
+		{&Float{Value: 2}, code.OpEquals, &object.Integer{Value: 2}, True},
+		{&object.Integer{Value: 2}, code.OpNotEquals, &Float{Value: 2.5}, True},
+		{&Float{Value: 2.5}, code.OpGreaterThan, &object.Integer{Value: 2}, True},
+		{&object.Integer{Value: 3}, code.OpGreaterThan, &Float{Value: 2.5}, True},
+		{bigInteger("9223372036854775808"), code.OpGreaterThan, &Float{Value: 1e18}, True},
+		{&Float{Value: math.NaN()}, code.OpEquals, &Float{Value: math.NaN()}, False},
+		{&Float{Value: math.NaN()}, code.OpGreaterThan, &Float{Value: 1}, False},
+	}
+
+	for _, tt := range tests {
+		bytecode := &compiler.Bytecode{Constants: []object.Object{tt.left, tt.right}}
+		bytecode.Instructions = concatInstructions(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(tt.op),
+			code.Make(code.OpPop),
+		)
+
+		vm := Create(bytecode)
+
+		err := vm.Run(nil)
+		if err != nil {
+			t.Fatalf("vm error for %s %s %s: %s", tt.left.Inspect(), OpcodeName(tt.op), tt.right.Inspect(), err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Type() != tt.expected.Type() || !valuesEqual(tt.expected, result, nil) {
+			t.Errorf("wrong result for %s %s %s. want=%s. got=%s (%s)", tt.left.Inspect(), OpcodeName(tt.op),
+				tt.right.Inspect(), tt.expected.Inspect(), result.Inspect(), result.Type())
+		}
+	}
+
+	bytecode := &compiler.Bytecode{Constants: []object.Object{&Float{Value: 1}, &Float{Value: 0}}}
+	bytecode.Instructions = concatInstructions(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpDivide),
+		code.Make(code.OpPop),
+	)
+
+	if err := Create(bytecode).Run(nil); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected division by zero. got=%v", err)
+	}
+}
+
+func TestVM_FloatLocals(t *testing.T) {
+	// fun(x) { x = x + 0.5; return x }(1.5)
+	add := &object.CompiledFunction{
+		Instructions: concatInstructions(
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpAdd),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpReturnValue),
+		),
+		NumLocals:     1,
+		NumParameters: 1,
+	}
+
+	bytecode := &compiler.Bytecode{Constants: []object.Object{add, &Float{Value: 0.5}, &Float{Value: 1.5}}}
+	bytecode.Instructions = concatInstructions(
+		code.Make(code.OpClosure, 0, 0),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpPop),
+	)
+
+	vm := Create(bytecode)
+
+	err := vm.Run(nil)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if result, ok := vm.LastPoppedStackElem().(*Float); !ok || result.Value != 2 {
+		t.Errorf("wrong result. want=2.0. got=%s", vm.LastPoppedStackElem().Inspect())
+	}
+}
+func TestFloat_Hash(t *testing.T) {
+	tests := []struct {
+		left  float64
+		right float64
+		equal bool
+	}{
+		{0, math.Copysign(0, -1), true},
+		{math.NaN(), -math.NaN(), true},
+		{1.5, 1.5, true},
+		{1.5, 2.5, false},
+		{math.Inf(1), math.Inf(-1), false},
+	}
+
+	for _, tt := range tests {
+		left, right := (&Float{Value: tt.left}).Hash(), (&Float{Value: tt.right}).Hash()
+		if (left == right) != tt.equal {
+			t.Errorf("wrong hash equality for %v and %v. want=%t", tt.left, tt.right, tt.equal)
+		}
+	}
+
+	// Values which are equal have to hash the same, otherwise a hashmap doesn't find them
+	integers := []struct {
+		float float64
+		other object.Hashable
+		equal bool
+	}{
+		{1, &object.Integer{Value: 1}, true},
+		{math.Copysign(0, -1), &object.Integer{Value: 0}, true},
+		{-9223372036854775808, &object.Integer{Value: math.MinInt64}, true},
+		{18446744073709551616, bigInteger("18446744073709551616"), true},
+		{1.5, &object.Integer{Value: 1}, false},
+		{9223372036854775807, &object.Integer{Value: math.MaxInt64}, false},
+	}
+
+	for _, tt := range integers {
+		float := &Float{Value: tt.float}
+		if valuesEqual(float, tt.other.(object.Object), nil) != tt.equal {
+			t.Errorf("wrong equality for %v and %s. want=%t", tt.float, tt.other.(object.Object).Inspect(), tt.equal)
+		}
+
+		if (float.Hash() == tt.other.Hash()) != tt.equal {
+			t.Errorf("wrong hash equality for %v and %s. want=%t", tt.float, tt.other.(object.Object).Inspect(), tt.equal)
+		}
+	}
+
+	// {1: "a"}[1.0]
+	bytecode := &compiler.Bytecode{Constants: []object.Object{&object.Integer{Value: 1}, &object.String{Value: "a"}, &Float{Value: 1}}}
+	bytecode.Instructions = concatInstructions(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpHash, 2),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpIndex),
+		code.Make(code.OpPop),
+	)
+
+	vm := Create(bytecode)
+	if err := vm.Run(nil); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, "a", vm.LastPoppedStackElem())
+}
+
+func TestFloat_Gob(t *testing.T) {
+	compiler.RegisterGobTypes()
+	RegisterGobTypes()
+
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(&compiler.Bytecode{Constants: []object.Object{&Float{Value: 2.5}}})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	var bytecode compiler.Bytecode
+
+	err = gob.NewDecoder(&buffer).Decode(&bytecode)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if f, ok := bytecode.Constants[0].(*Float); !ok || f.Value != 2.5 {
+		t.Errorf("wrong constant. got=%v", bytecode.Constants[0])
+	}
+}
+
+func TestVM_ModuloAndPower(t *testing.T) {
+	tests := []struct {
+		left     object.Object
+		op       code.OpCode
+		right    object.Object
+		mode     IntegerMode
+		expected interface{}
+	}{
+		{&object.Integer{Value: 7}, OpModulo, &object.Integer{Value: 3}, IntegerWrap, &object.Integer{Value: 1}},
+		{&object.Integer{Value: -7}, OpModulo, &object.Integer{Value: 3}, IntegerWrap, &object.Integer{Value: 2}},
+		{&object.Integer{Value: 7}, OpModulo, &object.Integer{Value: -3}, IntegerWrap, &object.Integer{Value: -2}},
+		{&object.Integer{Value: -7}, OpModulo, &object.Integer{Value: -3}, IntegerWrap, &object.Integer{Value: -1}},
+		{&object.Integer{Value: -6}, OpModulo, &object.Integer{Value: 3}, IntegerWrap, &object.Integer{Value: 0}},
+		{&object.Integer{Value: math.MinInt64}, OpModulo, &object.Integer{Value: -1}, IntegerChecked, &object.Integer{Value: 0}},
+		{&object.Integer{Value: 7}, OpModulo, &object.Integer{Value: 0}, IntegerWrap, ErrDivisionByZero},
+		{bigInteger("-9223372036854775809"), OpModulo, &object.Integer{Value: 10}, IntegerPromote, &object.Integer{Value: 1}},
+		{&Float{Value: -7.5}, OpModulo, &object.Integer{Value: 2}, IntegerWrap, &Float{Value: 0.5}},
+		{&Float{Value: 7.5}, OpModulo, &Float{Value: 0}, IntegerWrap, ErrDivisionByZero},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: 10}, IntegerWrap, &object.Integer{Value: 1024}},
+		{&object.Integer{Value: -3}, OpPower, &object.Integer{Value: 3}, IntegerWrap, &object.Integer{Value: -27}},
+		{&object.Integer{Value: 5}, OpPower, &object.Integer{Value: 0}, IntegerWrap, &object.Integer{Value: 1}},
+		{&object.Integer{Value: -2}, OpPower, &object.Integer{Value: 63}, IntegerChecked, &object.Integer{Value: math.MinInt64}},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: 63}, IntegerWrap, &object.Integer{Value: math.MinInt64}},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: 63}, IntegerChecked, ErrIntegerOverflow},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: 64}, IntegerPromote, bigInteger("18446744073709551616")},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: math.MaxInt64}, IntegerPromote, ErrIntegerOverflow},
+		{&object.Integer{Value: 1}, OpPower, &object.Integer{Value: math.MaxInt64}, IntegerChecked, &object.Integer{Value: 1}},
+		{&object.Integer{Value: 2}, OpPower, &object.Integer{Value: -1}, IntegerWrap, ErrNegativeExponent},
+		{&object.Integer{Value: 2}, OpPower, &Float{Value: -1}, IntegerWrap, &Float{Value: 0.5}},
+		{&object.String{Value: "a"}, OpModulo, &object.Integer{Value: 2}, IntegerWrap, &TypeError{OpCode: OpModulo}},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %s %s", tt.left.Inspect(), OpcodeName(tt.op), tt.right.Inspect())
+
+		bytecode := binaryOperation(tt.left, tt.op, tt.right)
+		if err := Verify(bytecode); err != nil {
+			t.Fatalf("verify error for %s: %s", name, err)
+		}
+
+		vm := Create(bytecode)
+		vm.SetIntegerMode(tt.mode)
+
+		err := vm.Run(nil)
+
+		if expectedErr, ok := tt.expected.(*TypeError); ok {
+			var typeErr *TypeError
+			if !errors.As(err, &typeErr) || typeErr.OpCode != expectedErr.OpCode {
+				t.Errorf("expected type error for %s. got=%v", name, err)
+			}
+
+			continue
+		}
+
+		if expectedErr, ok := tt.expected.(error); ok {
+			if !errors.Is(err, expectedErr) {
+				t.Errorf("expected error %q for %s. got=%v", expectedErr, name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("vm error for %s: %s", name, err)
+		}
+
+		expected := tt.expected.(object.Object)
+		result := vm.LastPoppedStackElem()
+		if result.Type() != expected.Type() || !valuesEqual(expected, result, nil) {
+			t.Errorf("wrong result for %s. want=%s. got=%s (%s)", name, expected.Inspect(), result.Inspect(), result.Type())
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for op, def := range definitions {
+		if _, err := code.Lookup(byte(op)); err == nil {
+			t.Errorf("%s overlaps an opcode of the compiler", def.Name)
+		}
+
+		if found, err := Lookup(byte(op)); err != nil || found != def {
+			t.Errorf("wrong definition for %s. got=%v (%v)", def.Name, found, err)
+		}
+	}
+
+	if def, err := Lookup(byte(code.OpSetIndex)); err != nil || def.Name != "OpSetIndex" {
+		t.Errorf("expected the compiler's opcodes to be found. got=%v (%v)", def, err)
+	}
+}
+
+// binaryOperation creates a program applying op to two constants, it can use opcodes the compiler doesn't emit.
+func binaryOperation(left object.Object, op code.OpCode, right object.Object) *compiler.Bytecode {
+	return &compiler.Bytecode{
+		Constants: []object.Object{left, right},
+		Instructions: concatInstructions(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			[]byte{byte(op)},
+			code.Make(code.OpPop),
+		),
+	}
+}